@@ -1,16 +1,24 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"nmid-registry/pkg/apiserver"
 	"nmid-registry/pkg/cluster"
 	"nmid-registry/pkg/envdir"
+	"nmid-registry/pkg/graceupdate"
 	"nmid-registry/pkg/loger"
 	"nmid-registry/pkg/option"
+	"nmid-registry/pkg/pidfile"
 	"nmid-registry/pkg/utils"
 	"os"
 	"sync"
+	"time"
 )
 
+//ShutdownTimeout the longest time to close, shorter than the cluster start timeout of the upgrade child.
+const ShutdownTimeout = time.Minute
+
 func main() {
 	//init option
 	opt := option.New()
@@ -22,6 +30,19 @@ func main() {
 		utils.Exit(0, msg)
 	}
 
+	//send the upgrade signal to the running server
+	if opt.SignalUpgrade {
+		pid, err := pidfile.Read(opt.AbsHomeDir)
+		if nil != err {
+			utils.Exit(1, fmt.Sprintf("read pid file failed %v", err))
+		}
+		err = utils.RaiseSignal(pid, utils.SignalUsr2)
+		if nil != err {
+			utils.Exit(1, fmt.Sprintf("send upgrade signal to %d failed %v", pid, err))
+		}
+		utils.Exit(0, fmt.Sprintf("upgrade signal sent to %d", pid))
+	}
+
 	//init env dir
 	err = envdir.InitEnvDir(opt)
 	if nil != err {
@@ -29,6 +50,12 @@ func main() {
 		utils.Exit(1, err.Error())
 	}
 
+	//the child of the upgrade writes the pid file when taking over, the parent keeps it till then.
+	if !graceupdate.IsInherit() {
+		writePidFile(opt.AbsHomeDir)
+	}
+	defer pidfile.Remove(opt.AbsHomeDir)
+
 	//new cluster
 	cls, err := cluster.NewCluster(opt)
 	if nil != err {
@@ -36,20 +63,48 @@ func main() {
 		utils.Exit(1, err.Error())
 	}
 
+	//the embedded etcd of the parent upgraded holds the data dir and the ports until it closed,
+	//so the master takes over at once, the clients wait in the backlog of the listeners inherited.
+	//the upgrade failed before taking over leaves the parent serving as before,
+	//failed after it, e.g. the etcd not ready in the cluster start timeout, the parent is gone too,
+	//and the member should be restarted by hand or by the supervisor.
+	if opt.ClusterRole == "master" {
+		takeOver(opt.AbsHomeDir)
+	}
+	err = cls.WaitReady()
+	if nil != err {
+		loger.Loger.Errorf("wait cluster ready failed %v", err)
+		utils.Exit(1, err.Error())
+	}
+
 	//new api server
 	apis, err := apiserver.NewApiServer(opt, cls)
 	if nil != err {
 		loger.Loger.Errorf("new cluster failed %v", err)
 		utils.Exit(1, err.Error())
 	}
+	if opt.ClusterRole != "master" {
+		takeOver(opt.AbsHomeDir)
+	}
 
-	//close nmid-registry by signal
+	//close nmid-registry by signal, upgrade by usr2, the child took over closes the parent by term.
 	sigChan := make(chan utils.Signal, 1)
-	if err := utils.NotifySignal(sigChan, utils.SignalInt, utils.SignalTerm); err != nil {
+	if err := utils.NotifySignal(sigChan, utils.SignalInt, utils.SignalTerm, utils.SignalUsr2); err != nil {
 		loger.Loger.Printf("failed to register signal %v", err)
 		os.Exit(1)
 	}
 	sig := <-sigChan
+	for sig == utils.SignalUsr2 {
+		process, err := graceupdate.ForkChild()
+		if errors.Is(err, graceupdate.ErrUpgrading) {
+			loger.Loger.Warnf("upgrade signal ignored, %v", err)
+		} else if nil != err {
+			loger.Loger.Errorf("upgrade failed %v", err)
+		} else {
+			loger.Loger.Infof("upgrade child %d started, close once it took over", process.Pid)
+		}
+		sig = <-sigChan
+	}
 	go func() {
 		sig := <-sigChan
 		loger.Loger.Infof("%s signal received, closing nmid-registry immediately", sig)
@@ -57,9 +112,40 @@ func main() {
 	}()
 	loger.Loger.Infof("%s signal received, closing nmid-registry", sig)
 
-	wg := &sync.WaitGroup{}
-	wg.Add(2)
-	apis.CloseApiServer(wg)
-	cls.CloseCluster(wg)
-	wg.Wait()
+	//the master child of the upgrade waits the etcd data dir released, exit anyway if the closing hangs.
+	closed := make(chan struct{})
+	go func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		apis.CloseApiServer(wg)
+		cls.CloseCluster(wg)
+		wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(ShutdownTimeout):
+		loger.Loger.Errorf("nmid-registry not closed in %s, exit", ShutdownTimeout)
+		pidfile.Remove(opt.AbsHomeDir)
+		os.Exit(1)
+	}
+}
+
+//takeOver take the pid file, then tell the parent of the upgrade to close.
+func takeOver(homeDir string) {
+	if !graceupdate.IsInherit() {
+		return
+	}
+	writePidFile(homeDir)
+	if err := graceupdate.NotifyParent(); err != nil {
+		loger.Loger.Errorf("notify parent failed %v", err)
+	}
+}
+
+func writePidFile(homeDir string) {
+	err := pidfile.Write(homeDir)
+	if nil != err {
+		loger.Loger.Errorf("write pid file failed %v", err)
+		utils.Exit(1, err.Error())
+	}
 }
@@ -2,9 +2,11 @@ package apiserver
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/go-kratos/kratos/pkg/ecode"
 	bm "github.com/go-kratos/kratos/pkg/net/http/blademaster"
+	"net/http"
+	"nmid-registry/pkg/cluster"
 	"nmid-registry/pkg/loger"
 	"nmid-registry/pkg/registry"
 )
@@ -19,7 +21,8 @@ func Register(c *bm.Context) {
 		return
 	}
 
-	if ins.Status == 0 || ins.Status > registry.InstanceError {
+	if !registry.ValidStatus(ins.Status) {
+		c.JSON(nil, ecode.RequestErr)
 		loger.Loger.Error("params status invalid")
 		return
 	}
@@ -28,15 +31,14 @@ func Register(c *bm.Context) {
 		// check the metadata type is json
 		if !json.Valid([]byte(arg.Metadata)) {
 			c.JSON(nil, ecode.RequestErr)
-			loger.Loger.Error("register params metadata(%v) invalid json", arg.Metadata)
+			loger.Loger.Errorf("register params metadata(%v) invalid json", arg.Metadata)
 			return
 		}
 	}
 
 	err := re.Register(c, arg, ins)
-	fmt.Println(`err`, err)
 	if nil != err {
-		c.JSON(false, nil)
+		c.JSON(false, err)
 		return
 	}
 
@@ -82,3 +84,161 @@ func DoWatch(c *bm.Context) {
 
 	c.JSON(ret, err)
 }
+
+func SetStatus(c *bm.Context) {
+	arg := new(registry.ArgSetStatus)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	if !registry.ValidStatus(arg.Status) {
+		c.JSON(nil, ecode.RequestErr)
+		loger.Loger.Errorf("set status params status(%d) invalid", arg.Status)
+		return
+	}
+
+	c.JSON(nil, re.SetStatus(c, arg))
+}
+
+func SetWeight(c *bm.Context) {
+	arg := new(registry.ArgSetWeight)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	if arg.Weight < 0 {
+		c.JSON(nil, ecode.RequestErr)
+		loger.Loger.Errorf("set weight params weight(%d) invalid", arg.Weight)
+		return
+	}
+
+	c.JSON(nil, re.SetWeight(c, arg))
+}
+
+func ReplicationStats(c *bm.Context) {
+	c.JSON(re.ReplicationStats(), nil)
+}
+
+func SetScheduler(c *bm.Context) {
+	arg := new(registry.ArgSetScheduler)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	c.JSON(nil, re.SetScheduler(c, arg))
+}
+
+func GetScheduler(c *bm.Context) {
+	arg := new(registry.ArgGetScheduler)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	c.JSON(re.GetScheduler(c, arg))
+}
+
+//Healthz the process alive.
+func Healthz(c *bm.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+//Readyz the member could serve, 503 with the reason if not.
+func Readyz(c *bm.Context) {
+	if err := cls.CheckReady(); err != nil {
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	c.String(http.StatusOK, "ok")
+}
+
+func ClusterMembers(c *bm.Context) {
+	members, err := cls.MembersStatus()
+	if nil != err {
+		loger.Loger.Errorf("get cluster members failed %v", err)
+		c.JSON(nil, ecode.ServerErr)
+		return
+	}
+
+	c.JSON(members, nil)
+}
+
+func EtcdMembers(c *bm.Context) {
+	members, err := cls.ListMembers()
+	if nil != err {
+		loger.Loger.Errorf("list etcd members failed %v", err)
+		c.JSON(nil, ecode.ServerErr)
+		return
+	}
+
+	c.JSON(members, nil)
+}
+
+func AddEtcdMember(c *bm.Context) {
+	arg := new(ArgAddMember)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	added, err := cls.AddMember(arg.Name, arg.PeerUrls, arg.Learner)
+	if nil != err {
+		c.JSON(nil, membershipError("add", err))
+		return
+	}
+
+	c.JSON(added, nil)
+}
+
+func PromoteEtcdMember(c *bm.Context) {
+	arg := new(ArgMember)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	if err := cls.PromoteMember(arg.ID); nil != err {
+		c.JSON(nil, membershipError("promote", err))
+		return
+	}
+
+	c.JSON(nil, nil)
+}
+
+func RemoveEtcdMember(c *bm.Context) {
+	arg := new(ArgMember)
+	if err := c.Bind(arg); err != nil {
+		return
+	}
+
+	if err := cls.RemoveMember(arg.ID); nil != err {
+		c.JSON(nil, membershipError("remove", err))
+		return
+	}
+
+	c.JSON(nil, nil)
+}
+
+//Snapshot save a snapshot to the backup dir of this member at once.
+func Snapshot(c *bm.Context) {
+	path, err := cls.Snapshot()
+	if nil != err {
+		loger.Loger.Errorf("snapshot failed %v", err)
+		c.JSON(nil, ecode.Error(ecode.ServerErr, err.Error()))
+		return
+	}
+
+	c.JSON(map[string]string{"path": path}, nil)
+}
+
+//membershipError tell the caller why the membership change refused.
+func membershipError(action string, err error) error {
+	loger.Loger.Errorf("%s etcd member failed %v", action, err)
+
+	switch {
+	case errors.Is(err, cluster.ErrMemberNotFound):
+		return ecode.Error(ecode.NothingFound, err.Error())
+	case errors.Is(err, cluster.ErrUnsafeMembership):
+		return ecode.Error(ecode.RequestErr, err.Error())
+	}
+
+	return ecode.Error(ecode.ServerErr, err.Error())
+}
@@ -0,0 +1,77 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	bm "github.com/go-kratos/kratos/pkg/net/http/blademaster"
+	xtime "github.com/go-kratos/kratos/pkg/time"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"nmid-registry/pkg/cluster"
+	"testing"
+	"time"
+)
+
+//fakeCluster the cluster ready or not by the error.
+type fakeCluster struct {
+	cluster.Cluster
+
+	readyErr error
+}
+
+func (fc *fakeCluster) CheckReady() error {
+	return fc.readyErr
+}
+
+//get the http status and body of the path from the router.
+func get(t *testing.T, path string) (int, string) {
+	t.Helper()
+
+	engine := bm.NewServer(&bm.ServerConfig{Timeout: xtime.Duration(time.Second)})
+	HttpRouter(engine)
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	body, _ := io.ReadAll(w.Result().Body)
+
+	return w.Code, string(body)
+}
+
+func TestHealthz(t *testing.T) {
+	if code, body := get(t, "/healthz"); code != http.StatusOK || body != "ok" {
+		t.Fatalf("healthz got %d %s", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	clsBefore := cls
+	defer func() { cls = clsBefore }()
+
+	cls = &fakeCluster{}
+	if code, body := get(t, "/readyz"); code != http.StatusOK || body != "ok" {
+		t.Fatalf("readyz got %d %s", code, body)
+	}
+
+	cls = &fakeCluster{readyErr: errors.New("etcd server is not ready")}
+	if code, body := get(t, "/readyz"); code != http.StatusServiceUnavailable || body != "etcd server is not ready" {
+		t.Fatalf("readyz not ready got %d %s", code, body)
+	}
+}
+
+func TestMembershipError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want ecode.Codes
+	}{
+		{fmt.Errorf("%w: 1a", cluster.ErrMemberNotFound), ecode.NothingFound},
+		{fmt.Errorf("%w: member 1a is the last voter", cluster.ErrUnsafeMembership), ecode.RequestErr},
+		{errors.New("etcdserver: request timed out"), ecode.ServerErr},
+	}
+	for _, c := range cases {
+		err := membershipError("remove", c.err)
+		if !ecode.EqualError(c.want, err) || err.(ecode.Codes).Message() != c.err.Error() {
+			t.Fatalf("membership error of %v got %v, want %v with the message", c.err, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"nmid-registry/pkg/graceupdate"
+	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/registry"
+	pb "nmid-registry/pkg/registrypb"
+	"time"
+)
+
+//GrpcServer the grpc api of the registry, shares the registry with the http routes.
+type GrpcServer struct {
+	pb.UnimplementedRegistryServer
+
+	server      *grpc.Server
+	stopTimeout time.Duration
+}
+
+func NewGrpcServer(addr string) (*GrpcServer, error) {
+	lis, err := graceupdate.Listen("grpc", addr)
+	if nil != err {
+		return nil, err
+	}
+
+	gs := &GrpcServer{
+		server: grpc.NewServer(
+			grpc.UnaryInterceptor(grpc_prometheus.UnaryServerInterceptor),
+			grpc.StreamInterceptor(grpc_prometheus.StreamServerInterceptor),
+		),
+		stopTimeout: GrpcStopTimeout,
+	}
+	pb.RegisterRegistryServer(gs.server, gs)
+	//the grpc_server_* metrics served on /metrics together with the embedded etcd ones, told apart by grpc_service.
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	grpc_prometheus.Register(gs.server)
+
+	go func() {
+		if err := gs.server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			loger.Loger.Errorf("grpc server error %v", err)
+		}
+	}()
+
+	return gs, nil
+}
+
+//Close wait the rpcs in flight done, the streams still open after the stop timeout are closed.
+func (gs *GrpcServer) Close() {
+	stopped := make(chan struct{})
+	go func() {
+		gs.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(gs.stopTimeout):
+		loger.Loger.Warnf("grpc server not stopped in %s, close the streams left", gs.stopTimeout)
+		gs.server.Stop()
+		<-stopped
+	}
+}
+
+func (gs *GrpcServer) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterReply, error) {
+	if req.ServiceId == "" || req.InflowAddr == "" || req.OutflowAddr == "" || req.Zone == "" || req.Env == "" || req.Hostname == "" {
+		return nil, status.Error(codes.InvalidArgument, "service_id, inflow_addr, outflow_addr, zone, env, hostname required")
+	}
+	if !registry.ValidStatus(req.Status) {
+		return nil, status.Errorf(codes.InvalidArgument, "params status(%d) invalid", req.Status)
+	}
+	if req.Weight < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "params weight(%d) invalid", req.Weight)
+	}
+
+	arg := &registry.ArgRegister{
+		ServiceId:       req.ServiceId,
+		InFlowAddr:      req.InflowAddr,
+		OutFlowAddr:     req.OutflowAddr,
+		Region:          req.Region,
+		Zone:            req.Zone,
+		Env:             req.Env,
+		Hostname:        req.Hostname,
+		Status:          req.Status,
+		Addrs:           req.Addrs,
+		Version:         req.Version,
+		Weight:          req.Weight,
+		LatestTimestamp: req.LatestTimestamp,
+		DirtyTimestamp:  req.DirtyTimestamp,
+		FromZone:        req.FromZone,
+	}
+	if len(req.Metadata) > 0 {
+		metadata, err := json.Marshal(req.Metadata)
+		if nil != err {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		arg.Metadata = string(metadata)
+	}
+
+	ins := registry.NewInstance(arg)
+
+	if err := re.Register(ctx, arg, ins); nil != err {
+		return nil, grpcError(err)
+	}
+
+	return &pb.RegisterReply{}, nil
+}
+
+func (gs *GrpcServer) Renew(ctx context.Context, req *pb.RenewRequest) (*pb.RenewReply, error) {
+	if req.ServiceId == "" || req.Zone == "" || req.Env == "" || req.Hostname == "" {
+		return nil, status.Error(codes.InvalidArgument, "service_id, zone, env, hostname required")
+	}
+
+	ins, err := re.Renew(ctx, &registry.ArgRenew{
+		ServiceId:      req.ServiceId,
+		InFlowAddr:     req.InflowAddr,
+		OutFlowAddr:    req.OutflowAddr,
+		Zone:           req.Zone,
+		Env:            req.Env,
+		Hostname:       req.Hostname,
+		Status:         req.Status,
+		DirtyTimestamp: req.DirtyTimestamp,
+		FromZone:       req.FromZone,
+	})
+	if nil != err {
+		return nil, grpcError(err)
+	}
+
+	return &pb.RenewReply{Instance: pbInstance(ins)}, nil
+}
+
+func (gs *GrpcServer) LogOff(ctx context.Context, req *pb.LogOffRequest) (*pb.LogOffReply, error) {
+	if req.ServiceId == "" || req.Zone == "" || req.Env == "" || req.Hostname == "" {
+		return nil, status.Error(codes.InvalidArgument, "service_id, zone, env, hostname required")
+	}
+
+	err := re.LogOff(ctx, &registry.ArgLogOff{
+		Zone:            req.Zone,
+		Env:             req.Env,
+		ServiceId:       req.ServiceId,
+		Hostname:        req.Hostname,
+		FromZone:        req.FromZone,
+		LatestTimestamp: req.LatestTimestamp,
+	})
+	if nil != err {
+		return nil, grpcError(err)
+	}
+
+	return &pb.LogOffReply{}, nil
+}
+
+func (gs *GrpcServer) Fetch(ctx context.Context, req *pb.FetchRequest) (*pb.FetchReply, error) {
+	if writeOnly || re.IsProtected() {
+		return nil, status.Error(codes.Unavailable, errMsg.Error())
+	}
+	if req.ServiceId == "" {
+		return nil, status.Error(codes.InvalidArgument, "service_id required")
+	}
+
+	info, err := re.FetchAll(ctx, &registry.ArgFetchAll{
+		ServiceId:       req.ServiceId,
+		Region:          req.Region,
+		Zone:            req.Zone,
+		Env:             req.Env,
+		Version:         req.Version,
+		Status:          req.Status,
+		LatestTimestamp: req.LatestTimestamp,
+	})
+	if ecode.EqualError(ecode.NotModified, err) {
+		return &pb.FetchReply{NotModified: true}, nil
+	}
+	if nil != err {
+		return nil, grpcError(err)
+	}
+
+	return &pb.FetchReply{Info: pbInstanceInfo(info)}, nil
+}
+
+//Watch send the current instances at once if the revision is 0, then every time any of the services changed.
+func (gs *GrpcServer) Watch(req *pb.WatchRequest, stream pb.Registry_WatchServer) error {
+	if len(req.ServiceIds) == 0 || req.Env == "" {
+		return status.Error(codes.InvalidArgument, "service_ids, env required")
+	}
+
+	arg := &registry.ArgDoWatch{
+		ServiceIds: req.ServiceIds,
+		Env:        req.Env,
+		Revision:   req.Revision,
+	}
+	for {
+		if writeOnly || re.IsProtected() {
+			return status.Error(codes.Unavailable, errMsg.Error())
+		}
+
+		wi, err := re.DoWatch(stream.Context(), arg)
+		if ecode.EqualError(ecode.NotModified, err) {
+			continue
+		}
+		if nil != err {
+			return grpcError(err)
+		}
+
+		reply := &pb.WatchReply{
+			Services: make(map[string]*pb.InstanceInfo, len(wi.Services)),
+			Revision: wi.Revision,
+		}
+		for serviceId, info := range wi.Services {
+			reply.Services[serviceId] = pbInstanceInfo(info)
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+
+		arg.Revision = wi.Revision
+	}
+}
+
+//grpcError convert the ecode of the registry to the grpc status.
+func grpcError(err error) error {
+	ec := ecode.Cause(err)
+	switch {
+	case ecode.EqualError(ecode.NothingFound, ec):
+		return status.Error(codes.NotFound, ec.Message())
+	case ecode.EqualError(ecode.Conflict, ec):
+		return status.Error(codes.Aborted, ec.Message())
+	case ecode.EqualError(ecode.RequestErr, ec):
+		return status.Error(codes.InvalidArgument, ec.Message())
+	case ecode.EqualError(ecode.ServiceUnavailable, ec):
+		return status.Error(codes.Unavailable, ec.Message())
+	case ecode.EqualError(ecode.Deadline, ec):
+		return status.Error(codes.DeadlineExceeded, ec.Message())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+func pbInstance(ins *registry.Instance) *pb.Instance {
+	if ins == nil {
+		return nil
+	}
+
+	return &pb.Instance{
+		ServiceId:       ins.ServiceId,
+		Region:          ins.Region,
+		Zone:            ins.Zone,
+		Env:             ins.Env,
+		Hostname:        ins.HostName,
+		Addrs:           ins.Addrs,
+		Version:         ins.Version,
+		Metadata:        ins.Metadata,
+		Weight:          ins.Weight,
+		Status:          ins.Status,
+		RegTimestamp:    ins.RegTimestamp,
+		UpTimestamp:     ins.UpTimestamp,
+		RenewTimestamp:  ins.RenewTimestamp,
+		DirtyTimestamp:  ins.DirtyTimestamp,
+		LatestTimestamp: ins.LatestTimestamp,
+	}
+}
+
+func pbInstanceInfo(info *registry.InstanceInfo) *pb.InstanceInfo {
+	if info == nil {
+		return nil
+	}
+
+	pi := &pb.InstanceInfo{
+		Instances:       make(map[string]*pb.Instances, len(info.Instances)),
+		LatestTimestamp: info.LatestTimestamp,
+	}
+	for zone, inss := range info.Instances {
+		zi := &pb.Instances{Instances: make([]*pb.Instance, 0, len(inss))}
+		for _, ins := range inss {
+			zi.Instances = append(zi.Instances, pbInstance(ins))
+		}
+		pi.Instances[zone] = zi
+	}
+	if info.Scheduler != nil {
+		pi.Scheduler = &pb.Scheduler{Zones: make([]*pb.ZoneScheduler, 0, len(info.Scheduler.Zones))}
+		for _, zone := range info.Scheduler.Zones {
+			pi.Scheduler.Zones = append(pi.Scheduler.Zones, &pb.ZoneScheduler{Src: zone.Src, Dst: zone.Dst})
+		}
+	}
+
+	return pi
+}
@@ -0,0 +1,317 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"net"
+	"net/http"
+	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/envdir"
+	"nmid-registry/pkg/option"
+	"nmid-registry/pkg/registry"
+	pb "nmid-registry/pkg/registrypb"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed %v", err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+//newTestGrpcClient boot a single member embedded cluster in a temp dir and the grpc api on it.
+func newTestGrpcClient(t *testing.T) pb.RegistryClient {
+	t.Helper()
+
+	peerUrl, clientUrl := "http://"+freeAddr(t), "http://"+freeAddr(t)
+	args := os.Args
+	os.Args = []string{args[0],
+		"--name", "test-member",
+		"--home-dir", t.TempDir(),
+		"--listen-peer-Urls", peerUrl,
+		"--initial-advertise-peer-Urls", peerUrl,
+		"--listen-client-Urls", clientUrl,
+		"--advertise-client-Urls", clientUrl,
+	}
+	defer func() { os.Args = args }()
+
+	opt := option.New()
+	if _, err := opt.Parse(); err != nil {
+		t.Fatalf("parse options failed %v", err)
+	}
+	if err := envdir.InitEnvDir(opt); err != nil {
+		t.Fatalf("init env dir failed %v", err)
+	}
+
+	c, err := cluster.NewCluster(opt)
+	if err != nil {
+		t.Fatalf("new cluster failed %v", err)
+	}
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		c.CloseCluster(wg)
+		wg.Wait()
+	})
+	clsBefore := cls
+	cls = c
+	t.Cleanup(func() { cls = clsBefore })
+	if err = cls.WaitReady(); err != nil {
+		t.Fatalf("wait cluster ready failed %v", err)
+	}
+
+	re = registry.NewRegistry(opt, cls)
+	t.Cleanup(re.Close)
+
+	addr := freeAddr(t)
+	gs, err := NewGrpcServer(addr)
+	if err != nil {
+		t.Fatalf("new grpc server failed %v", err)
+	}
+	t.Cleanup(gs.Close)
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial failed %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewRegistryClient(conn)
+}
+
+func TestGrpcRegistry(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	client := newTestGrpcClient(t)
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &pb.RegisterRequest{
+		ServiceId:   "test.service",
+		InflowAddr:  "127.0.0.1:2381",
+		OutflowAddr: "127.0.0.1:2381",
+		Zone:        "sh1",
+		Env:         "prod",
+		Hostname:    "host0",
+		Status:      registry.InstanceOk,
+		Addrs:       []string{"grpc://127.0.0.1:9000"},
+		Metadata:    map[string]string{"weight": "10"},
+	})
+	if err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	fetched, err := client.Fetch(ctx, &pb.FetchRequest{ServiceId: "test.service", Env: "prod"})
+	if err != nil {
+		t.Fatalf("fetch failed %v", err)
+	}
+	inss := fetched.Info.Instances["sh1"].GetInstances()
+	if len(inss) != 1 || inss[0].Hostname != "host0" || inss[0].Metadata["weight"] != "10" {
+		t.Fatalf("fetch got %v", fetched.Info)
+	}
+	notModified, err := client.Fetch(ctx, &pb.FetchRequest{ServiceId: "test.service", Env: "prod", LatestTimestamp: fetched.Info.LatestTimestamp})
+	if err != nil || !notModified.NotModified {
+		t.Fatalf("fetch with the latest timestamp got %v %v, want not modified", notModified, err)
+	}
+
+	renewed, err := client.Renew(ctx, &pb.RenewRequest{ServiceId: "test.service", Zone: "sh1", Env: "prod", Hostname: "host0"})
+	if err != nil || renewed.Instance.RenewTimestamp < inss[0].RenewTimestamp {
+		t.Fatalf("renew got %v %v", renewed, err)
+	}
+
+	stream, err := client.Watch(ctx, &pb.WatchRequest{ServiceIds: []string{"test.service"}, Env: "prod"})
+	if err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+	watched, err := stream.Recv()
+	if err != nil || watched.Revision == 0 || len(watched.Services["test.service"].GetInstances()["sh1"].GetInstances()) != 1 {
+		t.Fatalf("watch got %v %v", watched, err)
+	}
+
+	//the watch sends again once changed.
+	if _, err = client.LogOff(ctx, &pb.LogOffRequest{ServiceId: "test.service", Zone: "sh1", Env: "prod", Hostname: "host0"}); err != nil {
+		t.Fatalf("logoff failed %v", err)
+	}
+	watched, err = stream.Recv()
+	if err != nil || len(watched.Services["test.service"].GetInstances()) != 0 {
+		t.Fatalf("watch after logoff got %v %v", watched, err)
+	}
+
+	_, err = client.Renew(ctx, &pb.RenewRequest{ServiceId: "test.service", Zone: "sh1", Env: "prod", Hostname: "host0"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("renew after logoff got %v, want not found", err)
+	}
+}
+
+func TestGrpcProtected(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	client := newTestGrpcClient(t)
+	ctx := context.Background()
+
+	register := func(hostname string) error {
+		_, err := client.Register(ctx, &pb.RegisterRequest{
+			ServiceId:   "test.service",
+			InflowAddr:  "127.0.0.1:2381",
+			OutflowAddr: "127.0.0.1:2381",
+			Zone:        "sh1",
+			Env:         "prod",
+			Hostname:    hostname,
+			Status:      registry.InstanceOk,
+			Addrs:       []string{"grpc://127.0.0.1:9000"},
+		})
+		return err
+	}
+	for _, hostname := range []string{"host0", "host1"} {
+		if err := register(hostname); err != nil {
+			t.Fatalf("register failed %v", err)
+		}
+	}
+	re.RunEvict()
+
+	//all the instances gone at once, more likely the network than the instances.
+	for _, hostname := range []string{"host0", "host1"} {
+		kv, err := cls.GetRaw("/registry/prod/test.service/" + hostname)
+		if err != nil || kv == nil {
+			t.Fatalf("get instance %s got %v %v", hostname, kv, err)
+		}
+		if err = cls.RevokeLease(clientv3.LeaseID(kv.Lease)); err != nil {
+			t.Fatalf("revoke lease failed %v", err)
+		}
+	}
+	re.RunEvict()
+	if !re.IsProtected() {
+		t.Fatal("registry not in self preservation")
+	}
+
+	if _, err := client.Fetch(ctx, &pb.FetchRequest{ServiceId: "test.service", Env: "prod"}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("fetch in self preservation got %v, want unavailable", err)
+	}
+	stream, err := client.Watch(ctx, &pb.WatchRequest{ServiceIds: []string{"test.service"}, Env: "prod"})
+	if err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+	if _, err = stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("watch in self preservation got %v, want unavailable", err)
+	}
+	//refused by the code in the body, as the other errors of the routes.
+	if code, body := get(t, "/registry/fetch/all?service_id=test.service&env=prod"); code != http.StatusOK || !strings.Contains(body, `"code":-500`) {
+		t.Fatalf("http fetch in self preservation got %d %s, want refused", code, body)
+	}
+
+	//the writes are served, the instances come back by them.
+	if err = register("host0"); err != nil {
+		t.Fatalf("register in self preservation failed %v", err)
+	}
+}
+
+func TestGrpcInvalidArgument(t *testing.T) {
+	gs := &GrpcServer{}
+	ctx := context.Background()
+
+	_, err := gs.Register(ctx, &pb.RegisterRequest{ServiceId: "test.service"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("register without the required got %v", err)
+	}
+	_, err = gs.Register(ctx, &pb.RegisterRequest{ServiceId: "test.service", InflowAddr: "a", OutflowAddr: "a", Zone: "sh1", Env: "prod", Hostname: "host0", Status: registry.InstanceMaintenance + 1})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("register with an invalid status got %v", err)
+	}
+	if _, err = gs.Renew(ctx, &pb.RenewRequest{ServiceId: "test.service"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("renew without the required got %v", err)
+	}
+	if _, err = gs.LogOff(ctx, &pb.LogOffRequest{ServiceId: "test.service"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("logoff without the required got %v", err)
+	}
+	if err = gs.Watch(&pb.WatchRequest{Env: "prod"}, nil); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("watch without the services got %v", err)
+	}
+}
+
+func TestGrpcError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{ecode.NothingFound, codes.NotFound},
+		{ecode.Conflict, codes.Aborted},
+		{ecode.RequestErr, codes.InvalidArgument},
+		{ecode.ServiceUnavailable, codes.Unavailable},
+		{ecode.Deadline, codes.DeadlineExceeded},
+		{fmt.Errorf("etcd failed"), codes.Internal},
+	}
+	for _, c := range cases {
+		if code := status.Code(grpcError(c.err)); code != c.code {
+			t.Fatalf("grpc error of %v got %v, want %v", c.err, code, c.code)
+		}
+	}
+}
+
+//blockingDesc a stream only returns when closed, like a watch with nothing changed.
+var blockingDesc = grpc.ServiceDesc{
+	ServiceName: "test.Blocking",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Block",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			<-stream.Context().Done()
+			return stream.Context().Err()
+		},
+	}},
+}
+
+func TestGrpcCloseStopsStreamsLeft(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed %v", err)
+	}
+	gs := &GrpcServer{server: grpc.NewServer(), stopTimeout: 100 * time.Millisecond}
+	gs.server.RegisterService(&blockingDesc, struct{}{})
+	go gs.server.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial failed %v", err)
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(context.Background(), &blockingDesc.Streams[0], "/test.Blocking/Block")
+	if err != nil {
+		t.Fatalf("new stream failed %v", err)
+	}
+	if err = stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("send failed %v", err)
+	}
+	//wait the stream handled by the server.
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		gs.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close hung on the stream open")
+	}
+}
@@ -0,0 +1,11 @@
+package apiserver
+
+type ArgAddMember struct {
+	Name     string   `form:"name" binding:"required"`
+	PeerUrls []string `form:"peer_urls" binding:"required"`
+	Learner  bool     `form:"learner"` //not voting until promoted
+}
+
+type ArgMember struct {
+	ID string `form:"id" binding:"required"` //member id in hex
+}
@@ -3,24 +3,31 @@ package apiserver
 import (
 	"errors"
 	bm "github.com/go-kratos/kratos/pkg/net/http/blademaster"
+	"nmid-registry/pkg/cluster"
 	"nmid-registry/pkg/registry"
 )
 
 var (
 	re        *registry.Registry
+	cls       cluster.Cluster
 	writeOnly bool
 	errMsg    = errors.New("registry in protect mode & only can do register")
 )
 
 func DoApiServer(apiServer *ApiServer) {
 	writeOnly = apiServer.IsWriteOnly()
+	cls = apiServer.cluster
 
-	re = registry.NewRegistry(apiServer.cluster)
+	re = registry.NewRegistry(apiServer.option, apiServer.cluster)
 
 	HttpRouter(apiServer.server)
 }
 
 func HttpRouter(httpServer *bm.Engine) {
+	//for the load balancers, answered by the http status.
+	httpServer.GET("/healthz", Healthz)
+	httpServer.GET("/readyz", Readyz)
+
 	group := httpServer.Group("/registry")
 	{
 		group.POST("/register", Register)
@@ -29,11 +36,27 @@ func HttpRouter(httpServer *bm.Engine) {
 		group.GET("/fetch/all", WriteOnly, FetchAll)
 		group.POST("/watch", WriteOnly, DoWatch)
 	}
+
+	admin := httpServer.Group("/admin")
+	{
+		admin.POST("/instance/status", SetStatus)
+		admin.POST("/instance/weight", SetWeight)
+		admin.GET("/replication", ReplicationStats)
+		admin.POST("/scheduler", SetScheduler)
+		admin.GET("/scheduler", GetScheduler)
+		admin.GET("/cluster/members", ClusterMembers)
+		admin.GET("/cluster/etcd/members", EtcdMembers)
+		admin.POST("/cluster/etcd/member/add", AddEtcdMember)
+		admin.POST("/cluster/etcd/member/promote", PromoteEtcdMember)
+		admin.POST("/cluster/etcd/member/remove", RemoveEtcdMember)
+		admin.POST("/cluster/snapshot", Snapshot)
+	}
 }
 
 //WriteOnly if route write only can't do read operator like as fetch, fetchs fetchAll
+//registry in self preservation also turns into write only, the instances kept alive may be gone already.
 func WriteOnly(c *bm.Context) {
-	if writeOnly {
+	if writeOnly || re.IsProtected() {
 		c.JSON(nil, errMsg)
 		c.AbortWithStatus(503)
 	}
@@ -2,11 +2,17 @@ package apiserver
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	bm "github.com/go-kratos/kratos/pkg/net/http/blademaster"
 	xtime "github.com/go-kratos/kratos/pkg/time"
+	"net"
+	"net/http"
 	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/graceupdate"
 	"nmid-registry/pkg/loger"
 	"nmid-registry/pkg/option"
+	"nmid-registry/pkg/registry"
 	"sync"
 	"time"
 )
@@ -14,6 +20,11 @@ import (
 const (
 	ApiPrefix = ""
 	LockKey   = "/config/lock"
+
+	//the connections accepted right before the shutdown but read after it would be dropped.
+	ListenerDrainTime = 500 * time.Millisecond
+	//the grpc streams still open after it are closed at shutdown.
+	GrpcStopTimeout = 10 * time.Second
 )
 
 type (
@@ -25,8 +36,10 @@ type (
 
 		writeOnly bool
 
-		server  *bm.Engine
-		cluster cluster.Cluster
+		server     *bm.Engine
+		listener   net.Listener
+		grpcServer *GrpcServer
+		cluster    cluster.Cluster
 	}
 )
 
@@ -38,26 +51,74 @@ func NewApiServer(opt *option.Options, cls cluster.Cluster) (*ApiServer, error)
 	}
 
 	//http server
-	httpServer := bm.DefaultServer(&bm.ServerConfig{
+	conf := &bm.ServerConfig{
 		Timeout: xtime.Duration(30 * time.Second),
 		Addr:    opt.ApiAddr,
-	})
+	}
+	httpServer := bm.DefaultServer(conf)
 	apiServer.server = httpServer
 
 	DoApiServer(apiServer)
 
-	if err := apiServer.server.Start(); err != nil {
+	clock, err := cls.NewCMutex(LockKey)
+	if nil != err {
+		return nil, err
+	}
+	apiServer.clock = clock
+
+	//seed the objects before serving, the callers never see them missing.
+	if err = apiServer.importInitialObjects(); err != nil {
+		loger.Loger.Errorf("import initial objects failed %v", err)
+		return nil, err
+	}
+
+	//the listener may be inherited from the parent upgraded, handed over to the child in the next upgrade.
+	lis, err := graceupdate.Listen("http", opt.ApiAddr)
+	if err != nil {
 		loger.Loger.Errorf("http server error %v", err)
 		return nil, err
 	}
+	apiServer.listener = lis
+	server := &http.Server{
+		ReadTimeout:  time.Duration(conf.ReadTimeout),
+		WriteTimeout: time.Duration(conf.WriteTimeout),
+	}
+	go func() {
+		if err := httpServer.RunServer(server, lis); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			loger.Loger.Errorf("http server error %v", err)
+		}
+	}()
 	loger.Loger.Infof("http server start Listening on: %s", opt.ApiAddr)
 
+	//grpc server
+	if opt.GrpcAddr != "" {
+		grpcServer, err := NewGrpcServer(opt.GrpcAddr)
+		if err != nil {
+			loger.Loger.Errorf("grpc server error %v", err)
+			return nil, err
+		}
+		apiServer.grpcServer = grpcServer
+		loger.Loger.Infof("grpc server start Listening on: %s", opt.GrpcAddr)
+	}
+
 	return apiServer, nil
 }
 
 func (as *ApiServer) CloseApiServer(wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	//return the watches waiting for changes first, or the graceful stops would wait them forever.
+	//the registry itself is closed after the servers drained, the requests in flight still served.
+	re.CloseWatches()
+
+	if as.grpcServer != nil {
+		as.grpcServer.Close()
+	}
+
+	//stop accepting first, the requests of the connections accepted are served by the shutdown gracefully.
+	as.listener.Close()
+	time.Sleep(ListenerDrainTime)
+
 	err := func() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -67,6 +128,45 @@ func (as *ApiServer) CloseApiServer(wg *sync.WaitGroup) {
 	if nil != err {
 		loger.Loger.Errorf("http server shutdown failed %v", err)
 	}
+
+	re.Close()
+}
+
+//importInitialObjects create the objects of the initial object config files not exist yet,
+//under the cluster mutex so that the masters booting together do not import twice.
+func (as *ApiServer) importInitialObjects() error {
+	files := as.option.InitialObjectConfigFiles
+	if len(files) == 0 {
+		return nil
+	}
+
+	//all the files are checked before anything created.
+	objsArr := make([]*registry.InitialObjects, 0, len(files))
+	for _, file := range files {
+		objs, err := registry.LoadInitialObjects(file)
+		if nil != err {
+			return err
+		}
+		objsArr = append(objsArr, objs)
+	}
+
+	if err := as.clock.Lock(); err != nil {
+		return fmt.Errorf("lock %s failed: %v", LockKey, err)
+	}
+	defer func() {
+		if err := as.clock.Unlock(); err != nil {
+			loger.Loger.Errorf("unlock %s failed %v", LockKey, err)
+		}
+	}()
+
+	for i, objs := range objsArr {
+		if err := re.ImportInitialObjects(context.Background(), objs); err != nil {
+			return fmt.Errorf("import %s failed: %v", files[i], err)
+		}
+		loger.Loger.Infof("initial objects %s imported", files[i])
+	}
+
+	return nil
 }
 
 func (as *ApiServer) IsWriteOnly() bool {
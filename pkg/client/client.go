@@ -0,0 +1,196 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"io"
+	"net/http"
+	"net/url"
+	"nmid-registry/pkg/loger"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultTimeout       = 5 * time.Second
+	DefaultRenewInterval = 30 * time.Second
+
+	//longer than the poll wait time of the registry.
+	watchTimeout = 30 * time.Second
+)
+
+var ErrNoNodes = errors.New("no registry nodes")
+
+type Config struct {
+	Nodes         []string      //registry api addresses, the next one is tried when one fails
+	Env           string        //env of the services to fetch and watch
+	Timeout       time.Duration //timeout of each request
+	RenewInterval time.Duration //should be less than a third of the registry lease window
+}
+
+//Client register instances to the registry and keep them alive,
+//and keep a local cache of the services fetched up to date by watching them.
+type Client struct {
+	conf  *Config
+	nodes []string
+	node  uint32 //index of the node in use
+
+	httpClient *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	regMutex sync.Mutex
+	regs     map[string]*registration // hostname+serviceid -> registration
+
+	watchMutex  sync.Mutex
+	services    map[string]*InstanceInfo
+	subscribers map[string]map[chan struct{}]struct{}
+	watchChange chan struct{}
+	pollCancel  context.CancelFunc
+}
+
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func New(conf *Config) (*Client, error) {
+	if len(conf.Nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+	if conf.RenewInterval <= 0 {
+		conf.RenewInterval = DefaultRenewInterval
+	}
+
+	nodes := make([]string, 0, len(conf.Nodes))
+	for _, node := range conf.Nodes {
+		if !strings.HasPrefix(node, "http://") && !strings.HasPrefix(node, "https://") {
+			node = "http://" + node
+		}
+		nodes = append(nodes, strings.TrimSuffix(node, "/"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{
+		conf:        conf,
+		nodes:       nodes,
+		httpClient:  &http.Client{},
+		ctx:         ctx,
+		cancel:      cancel,
+		regs:        make(map[string]*registration),
+		services:    make(map[string]*InstanceInfo),
+		subscribers: make(map[string]map[chan struct{}]struct{}),
+		watchChange: make(chan struct{}, 1),
+	}
+
+	c.wg.Add(1)
+	go c.doWatch()
+
+	return c, nil
+}
+
+//Close log off all the instances registered, and stop renewing and watching.
+func (c *Client) Close() error {
+	c.regMutex.Lock()
+	regs := make([]*registration, 0, len(c.regs))
+	for _, reg := range c.regs {
+		regs = append(regs, reg)
+	}
+	c.regMutex.Unlock()
+
+	var err error
+	for _, reg := range regs {
+		if e := c.LogOff(reg.Registration); e != nil {
+			err = e
+		}
+	}
+
+	c.cancel()
+	c.wg.Wait()
+
+	return err
+}
+
+//call the registry api, turn to the next node when the node is unreachable or unavailable.
+func (c *Client) call(ctx context.Context, method, path string, params url.Values, timeout time.Duration, data interface{}) error {
+	var lastErr error
+	for i := 0; i < len(c.nodes); i++ {
+		idx := atomic.LoadUint32(&c.node)
+		node := c.nodes[int(idx)%len(c.nodes)]
+
+		resp, err := c.do(ctx, method, node+path, params, timeout)
+		if nil == err {
+			//the data of the error replied decoded too, e.g. the instance newer on conflict.
+			if data != nil && len(resp.Data) > 0 {
+				err = json.Unmarshal(resp.Data, data)
+			}
+			if resp.Code != 0 {
+				return ecode.Int(resp.Code)
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		loger.Loger.Warnf("registry node(%s) %s failed %v", node, path, err)
+		lastErr = err
+		atomic.CompareAndSwapUint32(&c.node, idx, idx+1)
+	}
+
+	return lastErr
+}
+
+func (c *Client) do(ctx context.Context, method, uri string, params url.Values, timeout time.Duration) (*response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		req *http.Request
+		err error
+	)
+	if method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, method, uri+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, uri, strings.NewReader(params.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if nil != err {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if nil != err {
+		return nil, err
+	}
+	//protect mode or the node is going down.
+	if res.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("http status %d", res.StatusCode)
+	}
+
+	resp := new(response)
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("http status %d invalid response %v", res.StatusCode, err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,397 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeRegistry the registry http api in memory, instances of the env prod only.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mutex     sync.Mutex
+	instances map[string]*Instance  // serviceid/hostname -> instance
+	forms     map[string][]string   // path -> the form of the requests, joined by hostname or service id
+	lastForms map[string]url.Values // path -> the form of the last request
+	revision  int64
+	changed   chan struct{}
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	fr := &fakeRegistry{
+		instances: make(map[string]*Instance),
+		forms:     make(map[string][]string),
+		lastForms: make(map[string]url.Values),
+		changed:   make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/registry/register", fr.register)
+	mux.HandleFunc("/registry/renew", fr.renew)
+	mux.HandleFunc("/registry/logoff", fr.logoff)
+	mux.HandleFunc("/registry/fetch/all", fr.fetch)
+	mux.HandleFunc("/registry/watch", fr.watch)
+	fr.Server = httptest.NewServer(mux)
+	t.Cleanup(fr.Close)
+
+	return fr
+}
+
+func reply(w http.ResponseWriter, code int, data interface{}) {
+	buff, _ := json.Marshal(data)
+	json.NewEncoder(w).Encode(&response{Code: code, Data: buff})
+}
+
+func (fr *fakeRegistry) record(r *http.Request) {
+	r.ParseForm()
+
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	fr.forms[r.URL.Path] = append(fr.forms[r.URL.Path], r.Form.Get("hostname")+r.Form.Get("service_id"))
+	fr.lastForms[r.URL.Path] = r.Form
+}
+
+func (fr *fakeRegistry) lastForm(path string) url.Values {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	return fr.lastForms[path]
+}
+
+func (fr *fakeRegistry) requests(path string) int {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	return len(fr.forms[path])
+}
+
+//put the instance and wake up the watches.
+func (fr *fakeRegistry) put(ins *Instance) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	fr.instances[ins.ServiceId+"/"+ins.HostName] = ins
+	fr.touch()
+}
+
+func (fr *fakeRegistry) touch() {
+	fr.revision++
+	close(fr.changed)
+	fr.changed = make(chan struct{})
+}
+
+func (fr *fakeRegistry) instance(serviceId, hostname string) *Instance {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	return fr.instances[serviceId+"/"+hostname]
+}
+
+//lose the instance, as if its lease expired.
+func (fr *fakeRegistry) lose(serviceId, hostname string) {
+	fr.mutex.Lock()
+	defer fr.mutex.Unlock()
+
+	delete(fr.instances, serviceId+"/"+hostname)
+	fr.touch()
+}
+
+func (fr *fakeRegistry) register(w http.ResponseWriter, r *http.Request) {
+	fr.record(r)
+
+	status, _ := strconv.ParseUint(r.Form.Get("status"), 10, 32)
+	weight, _ := strconv.ParseInt(r.Form.Get("weight"), 10, 64)
+	dirty, _ := strconv.ParseInt(r.Form.Get("dirty_timestamp"), 10, 64)
+	ins := &Instance{
+		ServiceId: r.Form.Get("service_id"),
+		Zone:      r.Form.Get("zone"),
+		Env:       r.Form.Get("env"),
+		HostName:  r.Form.Get("hostname"),
+		Addrs:     r.Form["addrs"],
+		Weight:    weight,
+		Status:    uint32(status),
+
+		DirtyTimestamp: dirty,
+	}
+	json.Unmarshal([]byte(r.Form.Get("metadata")), &ins.Metadata)
+	fr.put(ins)
+
+	reply(w, 0, true)
+}
+
+func (fr *fakeRegistry) renew(w http.ResponseWriter, r *http.Request) {
+	fr.record(r)
+
+	ins := fr.instance(r.Form.Get("service_id"), r.Form.Get("hostname"))
+	if ins == nil {
+		reply(w, ecode.NothingFound.Code(), nil)
+		return
+	}
+	//the dirty timestamps compared as the registry.
+	dirty, _ := strconv.ParseInt(r.Form.Get("dirty_timestamp"), 10, 64)
+	if dirty > ins.DirtyTimestamp {
+		reply(w, ecode.NothingFound.Code(), nil)
+		return
+	}
+	if dirty > 0 && dirty < ins.DirtyTimestamp {
+		reply(w, ecode.Conflict.Code(), ins)
+		return
+	}
+
+	reply(w, 0, ins)
+}
+
+func (fr *fakeRegistry) logoff(w http.ResponseWriter, r *http.Request) {
+	fr.record(r)
+	fr.lose(r.Form.Get("service_id"), r.Form.Get("hostname"))
+
+	reply(w, 0, nil)
+}
+
+//info the instances of the service, the caller holds the mutex.
+func (fr *fakeRegistry) info(serviceId string) *InstanceInfo {
+	info := &InstanceInfo{Instances: make(map[string][]*Instance), LatestTimestamp: fr.revision}
+	for _, ins := range fr.instances {
+		if ins.ServiceId == serviceId {
+			info.Instances[ins.Zone] = append(info.Instances[ins.Zone], ins)
+		}
+	}
+
+	return info
+}
+
+func (fr *fakeRegistry) fetch(w http.ResponseWriter, r *http.Request) {
+	fr.record(r)
+
+	fr.mutex.Lock()
+	info := fr.info(r.Form.Get("service_id"))
+	fr.mutex.Unlock()
+
+	reply(w, 0, info)
+}
+
+func (fr *fakeRegistry) watch(w http.ResponseWriter, r *http.Request) {
+	fr.record(r)
+	revision, _ := strconv.ParseInt(r.Form.Get("revision"), 10, 64)
+
+	fr.mutex.Lock()
+	changed := fr.changed
+	if revision == fr.revision {
+		fr.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			reply(w, ecode.NotModified.Code(), nil)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		fr.mutex.Lock()
+	}
+	wi := &watchInfo{Services: make(map[string]*InstanceInfo), Revision: fr.revision}
+	for _, serviceId := range r.Form["service_id"] {
+		wi.Services[serviceId] = fr.info(serviceId)
+	}
+	fr.mutex.Unlock()
+
+	reply(w, 0, wi)
+}
+
+func newTestClient(t *testing.T, nodes ...string) *Client {
+	t.Helper()
+
+	c, err := New(&Config{Nodes: nodes, Env: "prod", RenewInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("new client failed %v", err)
+	}
+
+	return c
+}
+
+func testRegistration(hostname string) *Registration {
+	return &Registration{
+		ServiceId:   "test.service",
+		InFlowAddr:  "127.0.0.1:2381",
+		OutFlowAddr: "127.0.0.1:2381",
+		Zone:        "sh1",
+		Env:         "prod",
+		Hostname:    hostname,
+		Addrs:       []string{"grpc://127.0.0.1:9000"},
+		Metadata:    map[string]string{"weight": "10"},
+		Weight:      20,
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&Config{}); err != ErrNoNodes {
+		t.Fatalf("new without nodes got %v, want no nodes", err)
+	}
+
+	c, err := New(&Config{Nodes: []string{"127.0.0.1:2381/"}})
+	if err != nil {
+		t.Fatalf("new failed %v", err)
+	}
+	defer c.Close()
+	if c.nodes[0] != "http://127.0.0.1:2381" || c.conf.Timeout != DefaultTimeout || c.conf.RenewInterval != DefaultRenewInterval {
+		t.Fatalf("new got node %s config %+v", c.nodes[0], c.conf)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	fr := newFakeRegistry(t)
+	c := newTestClient(t, fr.URL)
+
+	reg := testRegistration("host0")
+	if err := c.Register(reg); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	ins := fr.instance("test.service", "host0")
+	if ins == nil || ins.Status != InstanceOk || ins.Metadata["weight"] != "10" || ins.Weight != 20 || len(ins.Addrs) != 1 {
+		t.Fatalf("registered %+v", ins)
+	}
+	if err := c.Register(reg); err != ErrRegistered {
+		t.Fatalf("register again got %v, want registered", err)
+	}
+
+	//renewed in background, registered again once lost.
+	waitFor(t, "renew", func() bool { return fr.requests("/registry/renew") >= 2 })
+	fr.lose("test.service", "host0")
+	waitFor(t, "register again", func() bool { return fr.instance("test.service", "host0") != nil })
+
+	//log off and stop renewing on close.
+	if err := c.Close(); err != nil {
+		t.Fatalf("close failed %v", err)
+	}
+	if fr.instance("test.service", "host0") != nil || fr.requests("/registry/logoff") != 1 {
+		t.Fatal("instance not logged off on close")
+	}
+	renews := fr.requests("/registry/renew")
+	time.Sleep(50 * time.Millisecond)
+	if n := fr.requests("/registry/renew"); n != renews {
+		t.Fatalf("renewed %d times after closed", n-renews)
+	}
+}
+
+func TestRenewDirtyTimestamp(t *testing.T) {
+	fr := newFakeRegistry(t)
+	c := newTestClient(t, fr.URL)
+	defer c.Close()
+
+	if err := c.Register(testRegistration("host0")); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	dirty := fr.instance("test.service", "host0").DirtyTimestamp
+	if dirty == 0 {
+		t.Fatal("registered without the dirty timestamp")
+	}
+	waitFor(t, "renew", func() bool { return fr.requests("/registry/renew") >= 1 })
+	if form := fr.lastForm("/registry/renew"); form.Get("dirty_timestamp") != strconv.FormatInt(dirty, 10) {
+		t.Fatalf("renewed with dirty timestamp %s, want %d", form.Get("dirty_timestamp"), dirty)
+	}
+
+	//the registry holds an older one, registered again with ours.
+	fr.put(&Instance{ServiceId: "test.service", Env: "prod", HostName: "host0", DirtyTimestamp: dirty - 1})
+	waitFor(t, "register again", func() bool { return fr.instance("test.service", "host0").DirtyTimestamp == dirty })
+	if n := fr.requests("/registry/register"); n != 2 {
+		t.Fatalf("registered %d times, want registered again once", n)
+	}
+
+	//the registry holds a newer one, renewed as it without registering again.
+	fr.put(&Instance{ServiceId: "test.service", Env: "prod", HostName: "host0", Status: InstanceMaintenance, DirtyTimestamp: dirty + 1})
+	newer := strconv.FormatInt(dirty+1, 10)
+	waitFor(t, "renew the newer", func() bool { return fr.lastForm("/registry/renew").Get("dirty_timestamp") == newer })
+	if ins := fr.instance("test.service", "host0"); ins.Status != InstanceMaintenance || ins.DirtyTimestamp != dirty+1 {
+		t.Fatalf("the newer one replaced by %+v", ins)
+	}
+	if n := fr.requests("/registry/register"); n != 2 {
+		t.Fatalf("registered %d times, want not registered again on conflict", n)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	fr := newFakeRegistry(t)
+	c := newTestClient(t, down.URL, fr.URL)
+	defer c.Close()
+
+	if err := c.Register(testRegistration("host0")); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	if fr.instance("test.service", "host0") == nil {
+		t.Fatal("not registered to the node alive")
+	}
+	if c.nodes[int(c.node)%len(c.nodes)] != fr.URL {
+		t.Fatal("not turned to the node alive")
+	}
+}
+
+func TestFetchWatch(t *testing.T) {
+	fr := newFakeRegistry(t)
+	fr.put(&Instance{ServiceId: "test.service", Zone: "sh1", Env: "prod", HostName: "host0", Status: InstanceOk})
+	c := newTestClient(t, fr.URL)
+	defer c.Close()
+
+	info, err := c.Fetch("test.service")
+	if err != nil || len(info.Instances["sh1"]) != 1 {
+		t.Fatalf("fetch got %v %v", info, err)
+	}
+
+	//the cached one notified at once.
+	ch := c.Watch(context.Background(), "test.service")
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch of the cached not notified")
+	}
+
+	fr.put(&Instance{ServiceId: "test.service", Zone: "sh2", Env: "prod", HostName: "host1", Status: InstanceOk})
+	waitFor(t, "cache updated", func() bool {
+		info, err := c.Fetch("test.service")
+		return err == nil && len(info.Instances["sh2"]) == 1
+	})
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not notified after changed")
+	}
+	if n := fr.requests("/registry/fetch/all"); n != 1 {
+		t.Fatalf("fetched %d times from the registry, want the cache used", n)
+	}
+}
+
+func TestZoneWeights(t *testing.T) {
+	info := &InstanceInfo{}
+	if info.ZoneWeights("sh1") != nil {
+		t.Fatal("zone weights got without the scheduler")
+	}
+
+	info.Scheduler = &Scheduler{Zones: []*ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": 80, "sh2": 20}}}}
+	if weights := info.ZoneWeights("sh1"); weights["sh1"] != 80 || weights["sh2"] != 20 {
+		t.Fatalf("zone weights of sh1 got %v", weights)
+	}
+	if info.ZoneWeights("sh2") != nil {
+		t.Fatal("zone weights got for the zone not scheduled")
+	}
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"net/http"
+	"net/url"
+	"nmid-registry/pkg/loger"
+	"strconv"
+	"time"
+)
+
+type Instance struct {
+	ServiceId string
+	Region    string
+	Zone      string
+	Env       string
+	HostName  string
+	Addrs     []string
+	Version   string
+	Metadata  map[string]string
+	Weight    int64
+
+	Status uint32
+
+	RegTimestamp    int64
+	UpTimestamp     int64
+	RenewTimestamp  int64
+	DirtyTimestamp  int64
+	LatestTimestamp int64
+}
+
+//InstanceInfo the instances of a service grouped by zone.
+type InstanceInfo struct {
+	Instances       map[string][]*Instance `json:"instances"`
+	Scheduler       *Scheduler             `json:"scheduler,omitempty"`
+	LatestTimestamp int64                  `json:"latest_timestamp"`
+}
+
+//Scheduler the traffic weights from the callers of each zone to the zones of the service.
+type Scheduler struct {
+	Zones []*ZoneScheduler `json:"zones"`
+}
+
+type ZoneScheduler struct {
+	Src string           `json:"src"` // zone of the callers
+	Dst map[string]int64 `json:"dst"` // zone of the service -> weight
+}
+
+//ZoneWeights the weights of the zones of the service for the callers in the zone, nil if not scheduled.
+func (info *InstanceInfo) ZoneWeights(zone string) map[string]int64 {
+	if info.Scheduler == nil {
+		return nil
+	}
+	for _, zs := range info.Scheduler.Zones {
+		if zs.Src == zone {
+			return zs.Dst
+		}
+	}
+
+	return nil
+}
+
+type watchInfo struct {
+	Services map[string]*InstanceInfo `json:"services"`
+	Revision int64                    `json:"revision"`
+}
+
+//Fetch get the instances of the service from the local cache, fetched from the registry at the first time
+//and kept up to date by watching from then on. the instance info returned should not be modified.
+func (c *Client) Fetch(serviceId string) (*InstanceInfo, error) {
+	c.watchMutex.Lock()
+	info, ok := c.services[serviceId]
+	c.watchMutex.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	params := url.Values{}
+	params.Set("service_id", serviceId)
+	params.Set("env", c.conf.Env)
+	info = new(InstanceInfo)
+	if err := c.call(c.ctx, http.MethodGet, "/registry/fetch/all", params, c.conf.Timeout, info); err != nil {
+		return nil, err
+	}
+
+	c.watchMutex.Lock()
+	if _, ok := c.services[serviceId]; !ok {
+		c.services[serviceId] = info
+		c.watchChanged()
+	}
+	info = c.services[serviceId]
+	c.watchMutex.Unlock()
+
+	return info, nil
+}
+
+//Watch the service, the channel returned is notified every time the local cache of the service updated,
+//the subscription is canceled when the ctx done.
+func (c *Client) Watch(ctx context.Context, serviceId string) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	c.watchMutex.Lock()
+	subs, ok := c.subscribers[serviceId]
+	if !ok {
+		subs = make(map[chan struct{}]struct{})
+		c.subscribers[serviceId] = subs
+	}
+	subs[ch] = struct{}{}
+	if _, ok := c.services[serviceId]; ok {
+		ch <- struct{}{}
+	} else {
+		c.watchChanged()
+	}
+	c.watchMutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.ctx.Done():
+		}
+		c.watchMutex.Lock()
+		delete(c.subscribers[serviceId], ch)
+		c.watchMutex.Unlock()
+	}()
+
+	return ch
+}
+
+//watchChanged restart the long poll with the services watched now, must be called with watchMutex held.
+func (c *Client) watchChanged() {
+	if c.pollCancel != nil {
+		c.pollCancel()
+	}
+	select {
+	case c.watchChange <- struct{}{}:
+	default:
+	}
+}
+
+//serviceIds the services fetched or subscribed, must be called with watchMutex held.
+func (c *Client) serviceIds() []string {
+	ids := make([]string, 0, len(c.services)+len(c.subscribers))
+	for serviceId := range c.services {
+		ids = append(ids, serviceId)
+	}
+	for serviceId := range c.subscribers {
+		if _, ok := c.services[serviceId]; !ok {
+			ids = append(ids, serviceId)
+		}
+	}
+
+	return ids
+}
+
+//doWatch long poll all the services watched in one request, start over when the services changed.
+func (c *Client) doWatch() {
+	defer c.wg.Done()
+
+	var revision int64
+	for {
+		c.watchMutex.Lock()
+		ids := c.serviceIds()
+		ctx, cancel := context.WithCancel(c.ctx)
+		c.pollCancel = cancel
+		c.watchMutex.Unlock()
+
+		if len(ids) == 0 {
+			select {
+			case <-c.watchChange:
+			case <-c.ctx.Done():
+				cancel()
+				return
+			}
+			cancel()
+			continue
+		}
+
+		select {
+		case <-c.watchChange:
+			revision = 0 //get the new ones at once
+		default:
+		}
+
+		wi, err := c.watch(ctx, ids, revision)
+		changed := ctx.Err() != nil
+		cancel()
+		switch {
+		case c.ctx.Err() != nil:
+			return
+		case changed:
+		case ecode.EqualError(ecode.NotModified, err):
+		case nil != err:
+			loger.Loger.Errorf("watch services(%v) failed %v", ids, err)
+			revision = 0
+			select {
+			case <-time.After(time.Second):
+			case <-c.ctx.Done():
+				return
+			}
+		default:
+			revision = wi.Revision
+			c.update(wi)
+		}
+	}
+}
+
+func (c *Client) watch(ctx context.Context, ids []string, revision int64) (*watchInfo, error) {
+	params := url.Values{}
+	for _, serviceId := range ids {
+		params.Add("service_id", serviceId)
+	}
+	params.Set("env", c.conf.Env)
+	params.Set("revision", strconv.FormatInt(revision, 10))
+
+	wi := new(watchInfo)
+	if err := c.call(ctx, http.MethodPost, "/registry/watch", params, watchTimeout, wi); err != nil {
+		return nil, err
+	}
+
+	return wi, nil
+}
+
+//update the local cache and notify the subscribers.
+func (c *Client) update(wi *watchInfo) {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	for serviceId, info := range wi.Services {
+		c.services[serviceId] = info
+		for ch := range c.subscribers[serviceId] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"nmid-registry/pkg/loger"
+	"strconv"
+	"time"
+)
+
+//instance status, same as the registry.
+const (
+	InstanceOk = iota
+	InstanceError
+	InstanceDraining
+	InstanceMaintenance
+)
+
+var ErrRegistered = errors.New("instance already registered")
+
+//Registration the instance to register, same fields as the register api.
+type Registration struct {
+	ServiceId   string
+	InFlowAddr  string
+	OutFlowAddr string
+	Region      string
+	Zone        string
+	Env         string
+	Hostname    string
+	Status      uint32 //default InstanceOk
+	Addrs       []string
+	Version     string
+	Metadata    map[string]string
+	Weight      int64 //default 10 by the registry
+}
+
+type registration struct {
+	*Registration
+	dirty int64 //the dirty timestamp registered with, the registry keeps the instance of the newer one
+	done  chan struct{}
+}
+
+func (reg *Registration) key() string {
+	return reg.Env + "/" + reg.ServiceId + "/" + reg.Hostname
+}
+
+//Register the instance and keep renewing it until LogOff or Close.
+func (c *Client) Register(reg *Registration) error {
+	c.regMutex.Lock()
+	if _, ok := c.regs[reg.key()]; ok {
+		c.regMutex.Unlock()
+		return ErrRegistered
+	}
+	r := &registration{Registration: reg, dirty: time.Now().UnixNano(), done: make(chan struct{})}
+	c.regs[reg.key()] = r
+	c.regMutex.Unlock()
+
+	if err := c.register(r); err != nil {
+		c.regMutex.Lock()
+		delete(c.regs, reg.key())
+		c.regMutex.Unlock()
+		return err
+	}
+
+	c.wg.Add(1)
+	go c.doRenew(r)
+
+	return nil
+}
+
+//LogOff stop renewing the instance and remove it from the registry.
+func (c *Client) LogOff(reg *Registration) error {
+	c.regMutex.Lock()
+	r, ok := c.regs[reg.key()]
+	if ok {
+		delete(c.regs, reg.key())
+		close(r.done)
+	}
+	c.regMutex.Unlock()
+
+	params := url.Values{}
+	params.Set("service_id", reg.ServiceId)
+	params.Set("zone", reg.Zone)
+	params.Set("env", reg.Env)
+	params.Set("hostname", reg.Hostname)
+
+	err := c.call(c.ctx, http.MethodPost, "/registry/logoff", params, c.conf.Timeout, nil)
+	if ecode.EqualError(ecode.NothingFound, err) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *Client) register(reg *registration) error {
+	params := url.Values{}
+	params.Set("service_id", reg.ServiceId)
+	params.Set("inflow_addr", reg.InFlowAddr)
+	params.Set("outflow_addr", reg.OutFlowAddr)
+	params.Set("region", reg.Region)
+	params.Set("zone", reg.Zone)
+	params.Set("env", reg.Env)
+	params.Set("hostname", reg.Hostname)
+	params.Set("status", strconv.FormatUint(uint64(reg.Status), 10))
+	params.Set("version", reg.Version)
+	params.Set("dirty_timestamp", strconv.FormatInt(reg.dirty, 10))
+	if reg.Weight > 0 {
+		params.Set("weight", strconv.FormatInt(reg.Weight, 10))
+	}
+	for _, addr := range reg.Addrs {
+		params.Add("addrs", addr)
+	}
+	if len(reg.Metadata) > 0 {
+		metadata, err := json.Marshal(reg.Metadata)
+		if nil != err {
+			return err
+		}
+		params.Set("metadata", string(metadata))
+	}
+
+	var ok bool
+	if err := c.call(c.ctx, http.MethodPost, "/registry/register", params, c.conf.Timeout, &ok); err != nil {
+		return err
+	}
+	if !ok {
+		return ecode.ServerErr
+	}
+
+	return nil
+}
+
+//renew the instance, NothingFound if the registry lost it or holds an older one, register again then,
+//Conflict with the instance the registry holds if newer, e.g. registered again by another process or changed by the operators.
+func (c *Client) renew(reg *registration) (*Instance, error) {
+	params := url.Values{}
+	params.Set("service_id", reg.ServiceId)
+	params.Set("inflow_addr", reg.InFlowAddr)
+	params.Set("outflow_addr", reg.OutFlowAddr)
+	params.Set("zone", reg.Zone)
+	params.Set("env", reg.Env)
+	params.Set("hostname", reg.Hostname)
+	params.Set("status", strconv.FormatUint(uint64(reg.Status), 10))
+	params.Set("dirty_timestamp", strconv.FormatInt(reg.dirty, 10))
+
+	ins := new(Instance)
+	err := c.call(c.ctx, http.MethodPost, "/registry/renew", params, c.conf.Timeout, ins)
+
+	return ins, err
+}
+
+//doRenew renew the instance every renew interval with jitter, so that the instances of a service
+//started together do not renew at the same time. register again if the registry lost it.
+func (c *Client) doRenew(r *registration) {
+	defer c.wg.Done()
+
+	for {
+		interval := c.conf.RenewInterval
+		jitter := time.Duration(rand.Int63n(int64(interval)/5 + 1))
+		select {
+		case <-time.After(interval - interval/10 + jitter):
+		case <-r.done:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+
+		ins, err := c.renew(r)
+		if ecode.EqualError(ecode.NothingFound, err) {
+			loger.Loger.Warnf("renew service(%s) hostname(%s) not found, register again", r.ServiceId, r.Hostname)
+			err = c.register(r)
+		}
+		//the newer one kept by the registry, renewed as it from now on rather than conflicting on every renew.
+		if ecode.EqualError(ecode.Conflict, err) && ins.DirtyTimestamp > r.dirty {
+			loger.Loger.Warnf("renew service(%s) hostname(%s) conflict, the registry holds a newer one dirty at %d", r.ServiceId, r.Hostname, ins.DirtyTimestamp)
+			r.dirty = ins.DirtyTimestamp
+			err = nil
+		}
+		if nil != err {
+			loger.Loger.Errorf("renew service(%s) hostname(%s) failed %v", r.ServiceId, r.Hostname, err)
+		}
+	}
+}
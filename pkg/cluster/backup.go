@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"go.etcd.io/etcd/etcdutl/v3/snapshot"
+	"io"
+	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/option"
+	"nmid-registry/pkg/utils"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//快照备份与恢复
+const (
+	BackupFailedTime = 1 * time.Minute
+
+	SnapshotFilePrefix     = "snapshot-"
+	SnapshotFileSuffix     = ".db"
+	SnapshotTimeFormat     = "20060102T150405Z"
+	RestoredMarkerFilename = "restored-snapshot"
+	RestoreLogFileName     = "etcd_restore.log"
+)
+
+func (c *cluster) DoBackup() {
+	interval := c.options.BackupInterval
+	if interval <= 0 {
+		return
+	}
+
+	backuptime := interval
+	for {
+		select {
+		case <-time.After(backuptime):
+			backuptime = interval
+			if _, err := c.Snapshot(); err != nil {
+				loger.Loger.Errorf("backup failed %v", err)
+				backuptime = BackupFailedTime
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+//Snapshot save a snapshot of the cluster data to the backup dir, and remove the ones beyond the retention.
+func (c *cluster) Snapshot() (string, error) {
+	c.backupMutex.Lock()
+	defer c.backupMutex.Unlock()
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return "", err
+	}
+
+	//the snapshot may take long as the data grows, only canceled by the cluster closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	name := fmt.Sprintf("%s%s-%s%s", SnapshotFilePrefix, c.options.Name, start.UTC().Format(SnapshotTimeFormat), SnapshotFileSuffix)
+	path := filepath.Join(c.options.AbsBackupDir, name)
+	partPath := path + ".part"
+
+	rc, err := client.Snapshot(ctx)
+	if err != nil {
+		return "", fmt.Errorf("get snapshot failed: %v", err)
+	}
+	defer rc.Close()
+
+	size, err := writeSnapshot(partPath, rc)
+	if err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	if err = os.Rename(partPath, path); err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	loger.Loger.Infof("snapshot %s saved size(%d) in %v", path, size, time.Since(start))
+
+	c.rotateSnapshots()
+
+	return path, nil
+}
+
+func writeSnapshot(path string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return 0, fmt.Errorf("write snapshot failed: %v", err)
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+//rotateSnapshots keep the latest snapshots of this member, the time in the name sorts them.
+func (c *cluster) rotateSnapshots() {
+	pattern := filepath.Join(c.options.AbsBackupDir, SnapshotFilePrefix+c.options.Name+"-*"+SnapshotFileSuffix)
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		loger.Loger.Errorf("rotate snapshots failed %v", err)
+		return
+	}
+	if len(files) <= c.options.BackupRetention {
+		return
+	}
+
+	sort.Strings(files)
+	for _, file := range files[:len(files)-c.options.BackupRetention] {
+		if err := os.Remove(file); err != nil {
+			loger.Loger.Errorf("remove snapshot %s failed %v", file, err)
+			continue
+		}
+		loger.Loger.Infof("snapshot %s removed", file)
+	}
+}
+
+//RestoreSnapshot restore the data dir of the member from the snapshot before the embedded etcd starts,
+//the data dir in use moved aside, skipped if restored from the same snapshot already.
+func RestoreSnapshot(opt *option.Options, initialCluster string) error {
+	marker := filepath.Join(opt.AbsDataDir, RestoredMarkerFilename)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == opt.RestoreSnapshot {
+		loger.Loger.Infof("data dir restored from snapshot %s already, skip restoring", opt.RestoreSnapshot)
+		return nil
+	}
+
+	if !utils.IsDirEmpty(opt.AbsDataDir) {
+		aside := fmt.Sprintf("%s.bak-%s", opt.AbsDataDir, time.Now().UTC().Format(SnapshotTimeFormat))
+		if err := os.Rename(opt.AbsDataDir, aside); err != nil {
+			return fmt.Errorf("move data dir aside failed: %v", err)
+		}
+		loger.Loger.Infof("data dir %s moved to %s", opt.AbsDataDir, aside)
+	} else if err := os.RemoveAll(opt.AbsDataDir); err != nil {
+		return err
+	}
+
+	lg, err := ClientLoggerConfig(opt, RestoreLogFileName).Build()
+	if err != nil {
+		return err
+	}
+	err = snapshot.NewV3(lg).Restore(snapshot.RestoreConfig{
+		SnapshotPath:        opt.RestoreSnapshot,
+		Name:                opt.Name,
+		OutputDataDir:       opt.AbsDataDir,
+		PeerURLs:            opt.Cluster.InitialAdvertisePeerUrls,
+		InitialCluster:      initialCluster,
+		InitialClusterToken: opt.ClusterName,
+	})
+	if err != nil {
+		return fmt.Errorf("restore snapshot %s failed: %v", opt.RestoreSnapshot, err)
+	}
+
+	if err = os.WriteFile(marker, []byte(opt.RestoreSnapshot), 0o644); err != nil {
+		return err
+	}
+	loger.Loger.Infof("data dir %s restored from snapshot %s", opt.AbsDataDir, opt.RestoreSnapshot)
+
+	return nil
+}
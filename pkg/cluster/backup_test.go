@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"nmid-registry/pkg/option"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotateSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	c := &cluster{options: &option.Options{Name: "test-member", AbsBackupDir: dir, BackupRetention: 2}}
+
+	names := []string{
+		"snapshot-test-member-20260101T000000Z.db",
+		"snapshot-test-member-20260101T010000Z.db",
+		"snapshot-test-member-20260101T020000Z.db",
+		"snapshot-other-member-20260101T000000Z.db",
+		"snapshot-test-member-20260101T030000Z.db.part",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatalf("write %s failed %v", name, err)
+		}
+	}
+
+	c.rotateSnapshots()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir failed %v", err)
+	}
+	left := make(map[string]bool)
+	for _, entry := range entries {
+		left[entry.Name()] = true
+	}
+	if len(left) != 4 || left[names[0]] {
+		t.Fatalf("left %v, want the oldest of the member removed only", left)
+	}
+}
+
+func TestEmbeddedClusterSnapshotRestore(t *testing.T) {
+	cls := newTestCluster(t)
+	if err := cls.Put("/test/backup", "value"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+
+	path, err := cls.Snapshot()
+	if err != nil {
+		t.Fatalf("snapshot failed %v", err)
+	}
+	base := filepath.Base(path)
+	if !strings.HasPrefix(base, SnapshotFilePrefix+"test-member-") || !strings.HasSuffix(base, SnapshotFileSuffix) {
+		t.Fatalf("snapshot saved as %s", path)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("snapshot file %v %v", info, err)
+	}
+	if _, err = os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Fatalf("snapshot part file left %v", err)
+	}
+
+	//a new member started from the snapshot has the data.
+	restored := newTestCluster(t, "--restore-snapshot", path)
+	if value, err := restored.Get("/test/backup"); err != nil || value != "value" {
+		t.Fatalf("get from the restored got %q %v", value, err)
+	}
+	marker, err := os.ReadFile(filepath.Join(restored.(*cluster).options.AbsDataDir, RestoredMarkerFilename))
+	if err != nil || string(marker) != path {
+		t.Fatalf("restored marker %q %v", marker, err)
+	}
+}
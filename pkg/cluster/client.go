@@ -1,8 +1,11 @@
 package cluster
 
 import (
+	"fmt"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/option"
 	"time"
 )
 
@@ -29,13 +32,13 @@ func (c *cluster) GetClusterClient() (client *clientv3.Client, err error) {
 
 	var endpoints []string
 	if nil == c.members {
-		endpoints = c.options.GetPeerUrls()
+		endpoints = c.options.GetClientUrls()
 	} else {
 		endpoints = c.members.KnownPeerUrls()
 	}
 	loger.Loger.Infof("client connect with endpoints: %v", endpoints)
 
-	client, err = clientv3.New(clientv3.Config{
+	config := clientv3.Config{
 		Endpoints:            endpoints,
 		AutoSyncInterval:     AutoSyncTime,
 		DialTimeout:          DialTimeout,
@@ -43,7 +46,15 @@ func (c *cluster) GetClusterClient() (client *clientv3.Client, err error) {
 		DialKeepAliveTimeout: DialKeepAliveTimeout,
 		LogConfig:            ClientLoggerConfig(c.options, ClientLogFileName),
 		MaxCallSendMsgSize:   c.options.Cluster.MaxCallSendMsgSize,
-	})
+	}
+	if c.options.UseStandEtcd {
+		err = StandEtcdConfig(c.options, &config)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	client, err = clientv3.New(config)
 	if nil != err {
 		return nil, err
 	}
@@ -53,6 +64,30 @@ func (c *cluster) GetClusterClient() (client *clientv3.Client, err error) {
 	return client, nil
 }
 
+// StandEtcdConfig set the auth and tls of the standalone etcd to the client config.
+func StandEtcdConfig(opt *option.Options, config *clientv3.Config) error {
+	standEtcd := opt.StandEtcd
+	config.Username = standEtcd.Username
+	config.Password = standEtcd.Password
+
+	if standEtcd.CertFile == "" && standEtcd.TrustedCAFile == "" {
+		return nil
+	}
+
+	tlsInfo := transport.TLSInfo{
+		CertFile:      standEtcd.CertFile,
+		KeyFile:       standEtcd.KeyFile,
+		TrustedCAFile: standEtcd.TrustedCAFile,
+	}
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("stand etcd tls config failed: %v", err)
+	}
+	config.TLS = tlsConfig
+
+	return nil
+}
+
 func (c *cluster) CloseClusterClient() {
 	c.clientMutex.Lock()
 	defer c.clientMutex.Unlock()
@@ -30,9 +30,9 @@ const (
 
 type (
 	ClusterStatus struct {
-		ID        string `yaml:"id"`
-		State     string `yaml:"state"`
-		StartTime string `yaml:"startTime"`
+		ID        string `yaml:"id" json:"id"`
+		State     string `yaml:"state" json:"state"`
+		StartTime string `yaml:"startTime" json:"start_time"`
 	}
 
 	clusterStats struct {
@@ -46,28 +46,55 @@ type Cluster interface {
 	IsLeader() bool
 	Put(key, value string) error
 	PutUnderLease(key, value string) error
+	PutWithLease(key, value string, lease clientv3.LeaseID) error
+	Delete(key string) error
 	Get(key string) (string, error)
 	GetRaw(key string) (*mvccpb.KeyValue, error)
+	GetPrefix(prefix string) (map[string]string, error)
+	GetRawPrefix(prefix string) ([]*mvccpb.KeyValue, int64, error)
+	GrantLease(ttl int64) (clientv3.LeaseID, error)
+	KeepAliveLeaseOnce(leaseID clientv3.LeaseID) error
+	LeaseTimeToLive(leaseID clientv3.LeaseID) (int64, error)
+	RevokeLease(leaseID clientv3.LeaseID) error
+	WaitReady() error
+	CheckReady() error
+	MembersStatus() ([]*MemberInfo, error)
+	ListMembers() ([]*EtcdMember, error)
+	AddMember(name string, peerUrls []string, learner bool) (*MemberAdded, error)
+	PromoteMember(id string) error
+	RemoveMember(id string) error
+	Snapshot() (string, error)
+	NewCMutex(key string) (CMutex, error)
 	CloseCluster(wg *sync.WaitGroup)
-	DoWatch(key string) (chan<- WatchRet, error)
+	NewWatcher() (Watcher, error)
+	DoWatch(ctx context.Context, key string, opts ...WatchOption) (<-chan WatchEvent, error)
 }
 
 type cluster struct {
 	options        *option.Options
 	requestTimeout time.Duration
+	startTimeout   time.Duration
 
 	serverMutex  sync.RWMutex
 	clientMutex  sync.RWMutex
 	leaseMutex   sync.RWMutex
 	sessionMutex sync.RWMutex
+	watcherMutex sync.RWMutex
+	backupMutex  sync.Mutex
 
 	server  *embed.Etcd
 	client  *clientv3.Client
 	lease   *clientv3.LeaseID
 	session *concurrency.Session
-	members *Members
+	//the session of the cluster mutexes, not the member lease, so that the locks held by a dead node expire soon.
+	lockSession *concurrency.Session
+	members     *Members
 
-	done chan struct{}
+	watcher  *watcher
+	watchers map[*watcher]struct{}
+
+	ready chan struct{}
+	done  chan struct{}
 }
 
 func NewCluster(opt *option.Options) (Cluster, error) {
@@ -79,7 +106,12 @@ func NewCluster(opt *option.Options) (Cluster, error) {
 		return nil, fmt.Errorf("invalid cluster request timeout: %v", err)
 	}
 
-	if len(opt.GetPeerUrls()) == 0 {
+	startTimeout, err := time.ParseDuration(opt.ClusterStartTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster start timeout: %v", err)
+	}
+
+	if !opt.UseStandEtcd && len(opt.GetPeerUrls()) == 0 {
 		members, err = NewMembers(opt)
 		if err != nil {
 			return nil, fmt.Errorf("new members failed: %v", err)
@@ -90,12 +122,27 @@ func NewCluster(opt *option.Options) (Cluster, error) {
 		options:        opt,
 		members:        members,
 		requestTimeout: requestTimeout,
+		startTimeout:   startTimeout,
+		watchers:       make(map[*watcher]struct{}),
+		ready:          make(chan struct{}),
 		done:           make(chan struct{}),
 	}
 
+	go clu.ClusterRun()
+
 	return clu, nil
 }
 
+// WaitReady 等待集群启动完成, 超过启动超时时间返回错误
+func (c *cluster) WaitReady() error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-time.After(c.startTimeout):
+		return fmt.Errorf("cluster not ready in %v", c.startTimeout)
+	}
+}
+
 func (c *cluster) ClusterReady() (err error) {
 	if c.options.ClusterRole == "slave" {
 		_, err := c.GetClusterClient()
@@ -103,7 +150,11 @@ func (c *cluster) ClusterReady() (err error) {
 			return err
 		}
 
-		err = c.CheckClusterName()
+		if c.options.UseStandEtcd {
+			err = c.ClaimClusterName()
+		} else {
+			err = c.CheckClusterName()
+		}
 		if err != nil {
 			return err
 		}
@@ -142,11 +193,16 @@ func (c *cluster) ClusterRun() {
 	}
 
 	if err := tryReady(); nil != err {
+		loger.Loger.Errorf("cluster start failed %v", err)
 		for {
-			time.Sleep(HeartbeatTime)
+			select {
+			case <-time.After(HeartbeatTime):
+			case <-c.done:
+				return
+			}
 			err := tryReady()
 			if err != nil {
-				loger.Loger.Errorf("failed start many times(%d)", tryTimes)
+				loger.Loger.Errorf("failed start many times(%d) %v", tryTimes, err)
 			} else {
 				break
 			}
@@ -154,9 +210,11 @@ func (c *cluster) ClusterRun() {
 	}
 
 	loger.Loger.Infof("cluster is ready")
+	close(c.ready)
 
 	if c.options.ClusterRole == "master" {
 		go c.DoDefrag()
+		go c.DoBackup()
 	}
 
 	go c.BackendHandle()
@@ -170,8 +228,7 @@ func (c *cluster) CheckClusterName() error {
 
 	if len(value) > 0 {
 		if c.options.ClusterName != value {
-			loger.Loger.Errorf("clustername check mismatch local(%s) != exist(%s)", c.options.ClusterName, value)
-			panic(any(err))
+			return fmt.Errorf("clustername check mismatch local(%s) != exist(%s)", c.options.ClusterName, value)
 		}
 	} else {
 		return fmt.Errorf("key %s not found", NmClusterNameKey)
@@ -180,16 +237,48 @@ func (c *cluster) CheckClusterName() error {
 	return nil
 }
 
+// ClaimClusterName 独立etcd集群没有master写入集群名称, 第一个连接的成员写入, 之后的成员校验
+func (c *cluster) ClaimClusterName() error {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := func() (*clientv3.TxnResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(NmClusterNameKey), "=", 0)).
+			Then(clientv3.OpPut(NmClusterNameKey, c.options.ClusterName)).
+			Commit()
+	}()
+	if err != nil {
+		return fmt.Errorf("claim cluster name err %v", err)
+	}
+	if resp.Succeeded {
+		loger.Loger.Infof("cluster name %s claimed in stand etcd", c.options.ClusterName)
+		return nil
+	}
+
+	return c.CheckClusterName()
+}
+
 // BackendHandle 处理集群状态同步，更新成员信息
 func (c *cluster) BackendHandle() {
 	for {
 		select {
 		case <-time.After(HeartbeatTime):
+			metricIsLeader.Set(boolGauge(c.IsLeader()))
+
 			err := c.SyncStatus()
 			if err != nil {
 				loger.Loger.Errorf("sync status failed %v", err)
 			}
 
+			//the members of stand etcd are not managed by us.
+			if c.options.UseStandEtcd {
+				continue
+			}
 			err = c.UpdateMembers()
 			if err != nil {
 				loger.Loger.Errorf("update members failed %v", err)
@@ -203,8 +292,9 @@ func (c *cluster) BackendHandle() {
 
 // SyncStatus 同步状态
 func (c *cluster) SyncStatus() error {
+	//the status is readable by all the members, keep the credentials out.
 	status := MemberStatus{
-		Options: *c.options,
+		Options: c.options.Redacted(),
 	}
 
 	if c.options.ClusterRole == "master" {
@@ -253,6 +343,7 @@ func (c *cluster) UpdateMembers() error {
 		return err
 	}
 
+	metricMembers.Set(float64(len(resp.Members)))
 	if c.members != nil {
 		c.members.UpdateClusterMembers(resp.Members)
 	}
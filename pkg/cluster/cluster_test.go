@@ -0,0 +1,299 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"net"
+	"nmid-registry/pkg/envdir"
+	"nmid-registry/pkg/option"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed %v", err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+//newTestCluster boot a single member embedded cluster in a temp dir with the extra args, closed at the end of the test.
+func newTestCluster(t *testing.T, extraArgs ...string) Cluster {
+	t.Helper()
+
+	peerUrl := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	clientUrl := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	cls, _ := startTestCluster(t, t.TempDir(), peerUrl, clientUrl, extraArgs...)
+
+	return cls
+}
+
+//startTestCluster boot the member in the home dir, the close returned could be called before the end of the test.
+func startTestCluster(t *testing.T, homeDir, peerUrl, clientUrl string, extraArgs ...string) (Cluster, func()) {
+	t.Helper()
+
+	args := os.Args
+	os.Args = []string{args[0],
+		"--name", "test-member",
+		"--home-dir", homeDir,
+		"--cluster-start-timeout", "30s",
+		"--listen-peer-Urls", peerUrl,
+		"--initial-advertise-peer-Urls", peerUrl,
+		"--listen-client-Urls", clientUrl,
+		"--advertise-client-Urls", clientUrl,
+	}
+	os.Args = append(os.Args, extraArgs...)
+	defer func() { os.Args = args }()
+
+	opt := option.New()
+	if _, err := opt.Parse(); err != nil {
+		t.Fatalf("parse options failed %v", err)
+	}
+	if err := envdir.InitEnvDir(opt); err != nil {
+		t.Fatalf("init env dir failed %v", err)
+	}
+
+	cls, err := NewCluster(opt)
+	if err != nil {
+		t.Fatalf("new cluster failed %v", err)
+	}
+	once := sync.Once{}
+	closeCluster := func() {
+		once.Do(func() {
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			cls.CloseCluster(wg)
+			wg.Wait()
+		})
+	}
+	t.Cleanup(closeCluster)
+	if err = cls.WaitReady(); err != nil {
+		t.Fatalf("wait cluster ready failed %v", err)
+	}
+
+	return cls, closeCluster
+}
+
+func TestEmbeddedClusterPutGet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+
+	if err := cls.Put("/test/a", "1"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+	if err := cls.Put("/test/b", "2"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+	val, err := cls.Get("/test/a")
+	if err != nil || val != "1" {
+		t.Fatalf("get /test/a got %q %v, want 1", val, err)
+	}
+
+	kvs, err := cls.GetPrefix("/test/")
+	if err != nil || len(kvs) != 2 || kvs["/test/b"] != "2" {
+		t.Fatalf("get prefix got %v %v", kvs, err)
+	}
+
+	if err = cls.Delete("/test/a"); err != nil {
+		t.Fatalf("delete failed %v", err)
+	}
+	if val, err = cls.Get("/test/a"); err != nil || val != "" {
+		t.Fatalf("get deleted got %q %v", val, err)
+	}
+}
+
+func TestEmbeddedClusterLeaseTimeToLive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+
+	lease, err := cls.GrantLease(60)
+	if err != nil {
+		t.Fatalf("grant lease failed %v", err)
+	}
+	if ttl, err := cls.LeaseTimeToLive(lease); err != nil || ttl <= 0 || ttl > 60 {
+		t.Fatalf("lease ttl got %d %v, want (0, 60]", ttl, err)
+	}
+
+	if err = cls.RevokeLease(lease); err != nil {
+		t.Fatalf("revoke lease failed %v", err)
+	}
+	if ttl, err := cls.LeaseTimeToLive(lease); err != nil || ttl != -1 {
+		t.Fatalf("revoked lease ttl got %d %v, want -1", ttl, err)
+	}
+}
+
+func TestEmbeddedClusterWatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+
+	if err := cls.Put("/test/a", "1"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+	_, rev, err := cls.GetRawPrefix("/test/")
+	if err != nil {
+		t.Fatalf("get prefix failed %v", err)
+	}
+
+	//changed before the watch, sent first.
+	if err = cls.Put("/test/a", "2"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := cls.DoWatch(ctx, "/test/", WatchPrefix(), WatchFromRevision(rev+1))
+	if err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+	if err = cls.Delete("/test/a"); err != nil {
+		t.Fatalf("delete failed %v", err)
+	}
+
+	want := []WatchEvent{
+		{Type: mvccpb.PUT, Key: "/test/a", Value: "2", PrevValue: "1", Revision: rev + 1},
+		{Type: mvccpb.DELETE, Key: "/test/a", PrevValue: "2", Revision: rev + 2},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev != w {
+				t.Fatalf("event got %+v, want %+v", ev, w)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("event %+v not received", w)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("event received after canceled")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch not closed after canceled")
+	}
+}
+
+//newStandCluster a cluster connecting the endpoint as the standalone etcd, without starting it.
+func newStandCluster(t *testing.T, endpoint, clusterName string) *cluster {
+	t.Helper()
+
+	args := os.Args
+	os.Args = []string{args[0],
+		"--name", "stand-member",
+		"--home-dir", t.TempDir(),
+		"--cluster-name", clusterName,
+		"--use-stand-etcd",
+		"--stand-etcd-endpoints", endpoint,
+	}
+	defer func() { os.Args = args }()
+
+	opt := option.New()
+	if _, err := opt.Parse(); err != nil {
+		t.Fatalf("parse options failed %v", err)
+	}
+	if err := envdir.InitEnvDir(opt); err != nil {
+		t.Fatalf("init env dir failed %v", err)
+	}
+
+	c := &cluster{
+		options:        opt,
+		requestTimeout: 10 * time.Second,
+		done:           make(chan struct{}),
+	}
+	t.Cleanup(c.CloseClusterClient)
+
+	return c
+}
+
+func TestStandEtcdClaimClusterName(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+	endpoint := cls.(*cluster).options.GetClientUrls()[0]
+	name, err := cls.Get(NmClusterNameKey)
+	if err != nil || name == "" {
+		t.Fatalf("cluster name of the embedded got %q %v", name, err)
+	}
+
+	if err = newStandCluster(t, endpoint, name).ClaimClusterName(); err != nil {
+		t.Fatalf("claim the same cluster name failed %v", err)
+	}
+	if err = newStandCluster(t, endpoint, "other-cluster").ClaimClusterName(); err == nil {
+		t.Fatal("claim other cluster name succeeded, want mismatch")
+	}
+
+	//the first member of the standalone etcd claims the name.
+	if err = cls.Delete(NmClusterNameKey); err != nil {
+		t.Fatalf("delete cluster name failed %v", err)
+	}
+	if err = newStandCluster(t, endpoint, "other-cluster").ClaimClusterName(); err != nil {
+		t.Fatalf("claim cluster name failed %v", err)
+	}
+	if name, err = cls.Get(NmClusterNameKey); err != nil || name != "other-cluster" {
+		t.Fatalf("cluster name got %q %v, want other-cluster claimed", name, err)
+	}
+}
+
+func TestStandEtcdConfig(t *testing.T) {
+	opt := option.New()
+	opt.StandEtcd.Username = "root"
+	opt.StandEtcd.Password = "secret"
+
+	config := &clientv3.Config{}
+	if err := StandEtcdConfig(opt, config); err != nil {
+		t.Fatalf("stand etcd config failed %v", err)
+	}
+	if config.Username != "root" || config.Password != "secret" || config.TLS != nil {
+		t.Fatalf("config got %s %s %v, want the auth without tls", config.Username, config.Password, config.TLS)
+	}
+
+	opt.StandEtcd.CertFile = filepath.Join(t.TempDir(), "client.pem")
+	opt.StandEtcd.KeyFile = filepath.Join(t.TempDir(), "client-key.pem")
+	if err := StandEtcdConfig(opt, config); err == nil {
+		t.Fatal("stand etcd config with the cert files missing succeeded")
+	}
+}
+
+func TestEmbeddedClusterHealth(t *testing.T) {
+	cls := newTestCluster(t)
+
+	if err := cls.CheckReady(); err != nil {
+		t.Fatalf("check ready failed %v", err)
+	}
+
+	if err := cls.(*cluster).SyncStatus(); err != nil {
+		t.Fatalf("sync status failed %v", err)
+	}
+	members, err := cls.MembersStatus()
+	if err != nil {
+		t.Fatalf("members status failed %v", err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("got %d members, want 1", len(members))
+	}
+	member := members[0]
+	if member.Name != "test-member" || member.Role != "master" || !member.Leader || member.LastHeartbeatTime == "" {
+		t.Fatalf("member status %+v", member)
+	}
+	if member.Etcd == nil || member.Etcd.ID == "" {
+		t.Fatalf("member without the etcd status %+v", member)
+	}
+}
@@ -57,6 +57,7 @@ func CreateEtcdConfig(opt *option.Options) (*embed.Config, error) {
 		config.ClusterState = embed.ClusterStateFlagExisting
 	}
 	config.InitialCluster = opt.InitialCluster2String()
+	config.ForceNewCluster = opt.ForceNewCluster
 
 	return config, nil
 }
@@ -106,6 +107,7 @@ func CreateEtcdConfigAddMember(opt *option.Options, members *Members) (*embed.Co
 		return nil, fmt.Errorf("join mode with only one cluster member: %v", *members.ClusterMembers)
 	}
 	config.InitialCluster = members.InitCluster2String()
+	config.ForceNewCluster = opt.ForceNewCluster
 
 	return config, nil
 }
@@ -36,6 +36,7 @@ func (c *cluster) RunDefrag() time.Duration {
 		loger.Loger.Errorf("defrag err %v", err)
 		return DefragNormalTime
 	}
+	start := time.Now()
 	_, err = func() (*clientv3.DefragmentResponse, error) {
 		ctx, cancel := c.LongRequestContext()
 		defer cancel()
@@ -46,6 +47,7 @@ func (c *cluster) RunDefrag() time.Duration {
 		return DefragFailedTime
 	}
 
+	metricDefragDuration.Observe(time.Since(start).Seconds())
 	loger.Loger.Infof("defrag successfully")
 	return DefragNormalTime
 }
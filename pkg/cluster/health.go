@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strconv"
+)
+
+//MemberInfo the status of the member reported by its heartbeat, the member gone once its lease expired.
+type MemberInfo struct {
+	Name              string         `json:"name"`
+	ClusterName       string         `json:"cluster_name"`
+	Role              string         `json:"role"`
+	ApiAddr           string         `json:"api_addr"`
+	GrpcAddr          string         `json:"grpc_addr"`
+	LastHeartbeatTime string         `json:"last_heartbeat_time"`
+	LastDefragTime    string         `json:"last_defrag_time,omitempty"`
+	Etcd              *ClusterStatus `json:"etcd,omitempty"`
+	Leader            bool           `json:"leader"`
+}
+
+//CheckReady check the member could serve, the client connected, the lease granted and the etcd server ready for master.
+func (c *cluster) CheckReady() error {
+	select {
+	case <-c.ready:
+	default:
+		return fmt.Errorf("cluster is not ready")
+	}
+
+	if c.options.ClusterRole == "master" {
+		server, err := c.GetClusterServer()
+		if err != nil {
+			return err
+		}
+		select {
+		case <-server.Server.ReadyNotify():
+		default:
+			return fmt.Errorf("etcd server is not ready")
+		}
+		if server.Server.Leader() == 0 {
+			return fmt.Errorf("etcd server has no leader")
+		}
+	}
+
+	if _, err := c.GetLease(); err != nil {
+		return err
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+	if _, err = client.Get(ctx, NmClusterNameKey, clientv3.WithSerializable(), clientv3.WithCountOnly()); err != nil {
+		return fmt.Errorf("cluster client not connected: %v", err)
+	}
+
+	return nil
+}
+
+//MembersStatus the status of all the members alive, sorted by name.
+func (c *cluster) MembersStatus() ([]*MemberInfo, error) {
+	kvs, err := c.GetPrefix(StatusMemberPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	leader, err := c.leaderID()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*MemberInfo, 0, len(kvs))
+	for key, value := range kvs {
+		status := MemberStatus{}
+		if err := yaml.Unmarshal([]byte(value), &status); err != nil {
+			return nil, fmt.Errorf("unmarshal %s failed: %v", key, err)
+		}
+
+		member := &MemberInfo{
+			Name:              status.Options.Name,
+			ClusterName:       status.Options.ClusterName,
+			Role:              status.Options.ClusterRole,
+			ApiAddr:           status.Options.ApiAddr,
+			GrpcAddr:          status.Options.GrpcAddr,
+			LastHeartbeatTime: status.LastHeartbeatTime,
+			LastDefragTime:    status.LastDefragTime,
+			Etcd:              status.CStatus,
+		}
+		if status.CStatus != nil && status.CStatus.ID == leader {
+			member.Leader = true
+		}
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name < members[j].Name
+	})
+
+	return members, nil
+}
+
+//leaderID the etcd id of the leader in hex, the same as the id of the cluster status.
+func (c *cluster) leaderID() (string, error) {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for _, endpoint := range client.Endpoints() {
+		resp, err := func() (*clientv3.StatusResponse, error) {
+			ctx, cancel := c.RequestContext()
+			defer cancel()
+			return client.Status(ctx, endpoint)
+		}()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return strconv.FormatUint(resp.Leader, 16), nil
+	}
+
+	return "", fmt.Errorf("get leader failed: %v", lastErr)
+}
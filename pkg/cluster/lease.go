@@ -133,6 +133,7 @@ func (c *cluster) KeepAliveLease() {
 				return client.Lease.KeepAliveOnce(ctx, leaseID)
 			}()
 			if err != nil {
+				metricLeaseKeepAliveFailures.WithLabelValues("member").Inc()
 				loger.Loger.Errorf("keep alive for lease %x failed: %v", leaseID, err)
 				err := c.GenNewLease()
 				if err != nil {
@@ -165,3 +166,78 @@ func str2Lease(leaseStr string) (*clientv3.LeaseID, error) {
 
 	return &leaseID, nil
 }
+
+// GrantLease grant a lease for the keys outside the cluster members, like the registry instances.
+func (c *cluster) GrantLease(ttl int64) (clientv3.LeaseID, error) {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return 0, fmt.Errorf("lease get client err %v", err)
+	}
+
+	if ttl < MinTTL {
+		ttl = MinTTL
+	}
+
+	resp, err := func() (*clientv3.LeaseGrantResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Lease.Grant(ctx, ttl)
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.ID, nil
+}
+
+func (c *cluster) KeepAliveLeaseOnce(leaseID clientv3.LeaseID) error {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return fmt.Errorf("lease get client err %v", err)
+	}
+
+	_, err = func() (*clientv3.LeaseKeepAliveResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Lease.KeepAliveOnce(ctx, leaseID)
+	}()
+	if err != nil {
+		metricLeaseKeepAliveFailures.WithLabelValues("instance").Inc()
+	}
+
+	return err
+}
+
+// LeaseTimeToLive the remaining ttl in seconds of the lease, -1 if expired.
+func (c *cluster) LeaseTimeToLive(leaseID clientv3.LeaseID) (int64, error) {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return 0, fmt.Errorf("lease get client err %v", err)
+	}
+
+	resp, err := func() (*clientv3.LeaseTimeToLiveResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Lease.TimeToLive(ctx, leaseID)
+	}()
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.TTL, nil
+}
+
+func (c *cluster) RevokeLease(leaseID clientv3.LeaseID) error {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return fmt.Errorf("lease get client err %v", err)
+	}
+
+	_, err = func() (*clientv3.LeaseRevokeResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Lease.Revoke(ctx, leaseID)
+	}()
+
+	return err
+}
@@ -9,6 +9,7 @@ import (
 	"nmid-registry/pkg/option"
 	"nmid-registry/pkg/utils"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -61,6 +62,8 @@ func NewMembers(opt *option.Options) (*Members, error) {
 		Options:        opt,
 		ClusterMembers: &membersArr{},
 		KnownMembers:   &membersArr{},
+		dataFile:       filepath.Join(opt.AbsMemberDir, MembersFilename),
+		backupFile:     filepath.Join(opt.AbsMemberDir, MembersBackupFilename),
 	}
 	mem.initializeMembers(opt)
 	err := mem.loadFileData()
@@ -97,6 +100,11 @@ func (m *Members) GetSelf() *member {
 	m.Lock()
 	defer m.Unlock()
 
+	return m.getSelf()
+}
+
+//getSelf the caller should hold the lock.
+func (m *Members) getSelf() *member {
 	cm := m.ClusterMembers.GetMemberByName(m.Options.Name)
 	if cm != nil {
 		return cm
@@ -143,7 +151,7 @@ func (m *Members) KnownPeerUrls() []string {
 }
 
 func (m *Members) selfWithoutID() *member {
-	s := m.GetSelf()
+	s := m.getSelf()
 	s.ID = 0
 	return s
 }
@@ -193,8 +201,8 @@ func (m *Members) storeFileData() {
 		loger.Loger.Errorf("write file %s failed: %v", m.dataFile, err)
 	} else {
 		m.lastData = data
-		loger.Loger.Infof("store clusterMembers: %s", m.ClusterMembers)
-		loger.Loger.Infof("store knownMembers  : %s", m.KnownMembers)
+		loger.Loger.Infof("store clusterMembers: %v", *m.ClusterMembers)
+		loger.Loger.Infof("store knownMembers  : %v", *m.KnownMembers)
 	}
 }
 
@@ -202,13 +210,13 @@ func (m *Members) UpdateClusterMembers(pbMembers []*etcdserverpb.Member) {
 	m.Lock()
 	defer m.Unlock()
 
-	oldSelfID := m.GetSelf().ID
+	oldSelfID := m.getSelf().ID
 
 	ma := pbMembers2MembersArr(pbMembers)
 	ma.update(membersArr{m.selfWithoutID()})
 	m.ClusterMembers.replace(ma)
 
-	selfID := m.GetSelf().ID
+	selfID := m.getSelf().ID
 	if selfID != oldSelfID {
 		loger.Loger.Infof("self ID changed from %x to %x", oldSelfID, selfID)
 		//m.selfIDChanged = true
@@ -219,6 +227,37 @@ func (m *Members) UpdateClusterMembers(pbMembers []*etcdserverpb.Member) {
 	m.storeFileData()
 }
 
+//ResetMembers replace both the cluster and the known members, for the cluster forced to be new.
+func (m *Members) ResetMembers(pbMembers []*etcdserverpb.Member) {
+	m.Lock()
+	defer m.Unlock()
+
+	ma := pbMembers2MembersArr(pbMembers)
+	ma.update(membersArr{m.selfWithoutID()})
+	m.ClusterMembers.replace(ma)
+
+	known := make(membersArr, 0, len(ma))
+	for _, cm := range ma {
+		km := *cm
+		known = append(known, &km)
+	}
+	m.KnownMembers.replace(known)
+
+	m.storeFileData()
+}
+
+//RemoveKnownMember forget the member removed from the cluster, or the client would keep connecting it.
+func (m *Members) RemoveKnownMember(peerUrls []string) {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, peerUrl := range peerUrls {
+		m.KnownMembers.remove(peerUrl)
+	}
+
+	m.storeFileData()
+}
+
 func (ma membersArr) Len() int           { return len(ma) }
 func (ma membersArr) Swap(i, j int)      { ma[i], ma[j] = ma[j], ma[i] }
 func (ma membersArr) Less(i, j int) bool { return ma[i].Name < ma[j].Name }
@@ -278,16 +317,25 @@ func (ma *membersArr) update(updateMembers membersArr) {
 					m.ID = updateMember.ID
 				}
 			}
+		}
 
-			if !found {
-				*ma = append(*ma, updateMember)
-			}
+		if !found {
+			*ma = append(*ma, updateMember)
 		}
 	}
 
 	sort.Sort(*ma)
 }
 
+func (ma *membersArr) remove(peerUrl string) {
+	for i, m := range *ma {
+		if m.PeerUrl == peerUrl {
+			*ma = append((*ma)[:i], (*ma)[i+1:]...)
+			return
+		}
+	}
+}
+
 func (ma *membersArr) replace(replaceMembers membersArr) {
 	*ma = replaceMembers
 }
@@ -0,0 +1,280 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/option"
+	"nmid-registry/pkg/utils"
+	"strconv"
+)
+
+var (
+	ErrMemberNotFound   = errors.New("member not found")
+	ErrUnsafeMembership = errors.New("unsafe membership change")
+)
+
+// EtcdMember the member of the etcd cluster, the name is empty until the member started.
+type EtcdMember struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	PeerUrls   []string `json:"peer_urls"`
+	ClientUrls []string `json:"client_urls"`
+	IsLearner  bool     `json:"is_learner"`
+}
+
+// MemberAdded the member added, and the initial cluster for it to start with.
+type MemberAdded struct {
+	Member         *EtcdMember `json:"member"`
+	InitialCluster string      `json:"initial_cluster"`
+}
+
+func newEtcdMember(m *etcdserverpb.Member) *EtcdMember {
+	return &EtcdMember{
+		ID:         strconv.FormatUint(m.ID, 16),
+		Name:       m.Name,
+		PeerUrls:   m.PeerURLs,
+		ClientUrls: m.ClientURLs,
+		IsLearner:  m.IsLearner,
+	}
+}
+
+func parseMemberID(id string) (uint64, error) {
+	memberID, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid member id %s", ErrMemberNotFound, id)
+	}
+
+	return memberID, nil
+}
+
+func (c *cluster) memberList() ([]*etcdserverpb.Member, error) {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+	resp, err := client.MemberList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Members, nil
+}
+
+// ListMembers the members of the etcd cluster.
+func (c *cluster) ListMembers() ([]*EtcdMember, error) {
+	pbMembers, err := c.memberList()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*EtcdMember, 0, len(pbMembers))
+	for _, m := range pbMembers {
+		members = append(members, newEtcdMember(m))
+	}
+
+	return members, nil
+}
+
+// AddMember add a member with the peer urls, as a learner not voting until promoted if asked,
+// the new member should start by the name with the initial cluster returned and the cluster state existing.
+func (c *cluster) AddMember(name string, peerUrls []string, learner bool) (*MemberAdded, error) {
+	if c.options.UseStandEtcd {
+		return nil, fmt.Errorf("%w: the members of stand etcd are not managed by us", ErrUnsafeMembership)
+	}
+	if err := utils.ValidateName(name); err != nil {
+		return nil, fmt.Errorf("%w: invalid name %v", ErrUnsafeMembership, err)
+	}
+	if len(peerUrls) == 0 {
+		return nil, fmt.Errorf("%w: empty peer urls", ErrUnsafeMembership)
+	}
+	if _, err := option.ParseUrls(peerUrls); err != nil {
+		return nil, fmt.Errorf("%w: invalid peer urls %v", ErrUnsafeMembership, err)
+	}
+
+	pbMembers, err := c.memberList()
+	if err != nil {
+		return nil, err
+	}
+	//a voter not started yet counts in the quorum, another one may lose it.
+	for _, m := range pbMembers {
+		if m.Name == name {
+			return nil, fmt.Errorf("%w: member %s exists", ErrUnsafeMembership, name)
+		}
+		if !learner && !m.IsLearner && m.Name == "" {
+			return nil, fmt.Errorf("%w: member %x not started yet", ErrUnsafeMembership, m.ID)
+		}
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := func() (*clientv3.MemberAddResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		if learner {
+			return client.MemberAddAsLearner(ctx, peerUrls)
+		}
+		return client.MemberAdd(ctx, peerUrls)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	loger.Loger.Infof("member %s(%x) added peer urls %v learner(%v)", name, resp.Member.ID, peerUrls, learner)
+
+	//the name of the new member known by etcd only after it started.
+	added := &MemberAdded{Member: newEtcdMember(resp.Member)}
+	added.Member.Name = name
+	initialCluster := pbMembers2MembersArr(resp.Members)
+	for _, m := range initialCluster {
+		if m.ID == resp.Member.ID {
+			m.Name = name
+		}
+	}
+	added.InitialCluster = initialCluster.initCluster2String()
+
+	c.updateMembersAfterChange()
+
+	return added, nil
+}
+
+// PromoteMember promote the learner to a voter, etcd refuses it if the learner not in sync with the leader.
+func (c *cluster) PromoteMember(id string) error {
+	memberID, err := parseMemberID(id)
+	if err != nil {
+		return err
+	}
+
+	pbMembers, err := c.memberList()
+	if err != nil {
+		return err
+	}
+	target := findMember(pbMembers, memberID)
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, id)
+	}
+	if !target.IsLearner {
+		return fmt.Errorf("%w: member %s is not a learner", ErrUnsafeMembership, id)
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+	if _, err = client.MemberPromote(ctx, memberID); err != nil {
+		return err
+	}
+	loger.Loger.Infof("member %s(%s) promoted", id, target.Name)
+
+	c.updateMembersAfterChange()
+
+	return nil
+}
+
+// RemoveMember remove the member, refuse to remove the last voter, itself,
+// or a voter when the healthy voters left would not make the quorum.
+func (c *cluster) RemoveMember(id string) error {
+	memberID, err := parseMemberID(id)
+	if err != nil {
+		return err
+	}
+
+	pbMembers, err := c.memberList()
+	if err != nil {
+		return err
+	}
+	target := findMember(pbMembers, memberID)
+	if target == nil {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, id)
+	}
+
+	if server, err := c.GetClusterServer(); err == nil && uint64(server.Server.ID()) == memberID {
+		return fmt.Errorf("%w: member %s is the one serving, remove it from another member", ErrUnsafeMembership, id)
+	}
+
+	if !target.IsLearner {
+		voters, healthy := 0, 0
+		for _, m := range pbMembers {
+			if m.IsLearner || m.ID == memberID {
+				continue
+			}
+			voters++
+			if c.memberHealthy(m) {
+				healthy++
+			}
+		}
+		if voters == 0 {
+			return fmt.Errorf("%w: member %s is the last voter", ErrUnsafeMembership, id)
+		}
+		if quorum := voters/2 + 1; healthy < quorum {
+			return fmt.Errorf("%w: only %d healthy voters left, quorum of %d voters is %d", ErrUnsafeMembership, healthy, voters, quorum)
+		}
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+	if _, err = client.MemberRemove(ctx, memberID); err != nil {
+		return err
+	}
+	loger.Loger.Infof("member %s(%s) removed peer urls %v", id, target.Name, target.PeerURLs)
+
+	if c.members != nil {
+		c.members.RemoveKnownMember(target.PeerURLs)
+	}
+	c.updateMembersAfterChange()
+
+	return nil
+}
+
+// memberHealthy the member started and answers on any of its client urls.
+func (c *cluster) memberHealthy(m *etcdserverpb.Member) bool {
+	if m.Name == "" {
+		return false
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return false
+	}
+	for _, endpoint := range m.ClientURLs {
+		ok := func() bool {
+			ctx, cancel := c.RequestContext()
+			defer cancel()
+			_, err := client.Status(ctx, endpoint)
+			return err == nil
+		}()
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *cluster) updateMembersAfterChange() {
+	if err := c.UpdateMembers(); err != nil {
+		loger.Loger.Errorf("update members failed %v", err)
+	}
+}
+
+func findMember(pbMembers []*etcdserverpb.Member, id uint64) *etcdserverpb.Member {
+	for _, m := range pbMembers {
+		if m.ID == id {
+			return m
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMembersArrUpdate(t *testing.T) {
+	ma := membersArr{{Name: "b", PeerUrl: "http://b:2380"}}
+	ma.update(membersArr{
+		{ID: 2, PeerUrl: "http://b:2380"},
+		{ID: 1, Name: "a", PeerUrl: "http://a:2380"},
+		{Name: "c"},
+	})
+
+	if len(ma) != 2 || ma[0].Name != "a" || ma[1].Name != "b" || ma[1].ID != 2 {
+		t.Fatalf("updated members %v", ma)
+	}
+
+	ma.remove("http://a:2380")
+	ma.remove("http://c:2380")
+	if len(ma) != 1 || ma[0].Name != "b" {
+		t.Fatalf("removed members %v", ma)
+	}
+}
+
+func TestEmbeddedClusterMembership(t *testing.T) {
+	cls := newTestCluster(t)
+
+	members, err := cls.ListMembers()
+	if err != nil {
+		t.Fatalf("list members failed %v", err)
+	}
+	if len(members) != 1 || members[0].Name != "test-member" || members[0].IsLearner {
+		t.Fatalf("members %+v, want the only voter", members)
+	}
+	self := members[0].ID
+
+	learnerUrl := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	refused := []struct {
+		name     string
+		peerUrls []string
+	}{
+		{"", []string{learnerUrl}},
+		{"learner", nil},
+		{"learner", []string{"127.0.0.1:port"}},
+		{"test-member", []string{learnerUrl}},
+	}
+	for _, r := range refused {
+		if _, err = cls.AddMember(r.name, r.peerUrls, true); !errors.Is(err, ErrUnsafeMembership) {
+			t.Fatalf("add member %q %v got %v, want unsafe", r.name, r.peerUrls, err)
+		}
+	}
+
+	//a learner not voting, safe to add to the single member cluster.
+	added, err := cls.AddMember("learner", []string{learnerUrl}, true)
+	if err != nil {
+		t.Fatalf("add learner failed %v", err)
+	}
+	if !added.Member.IsLearner || added.Member.Name != "learner" {
+		t.Fatalf("added %+v, want the learner", added.Member)
+	}
+	if !strings.Contains(added.InitialCluster, "learner="+learnerUrl) || !strings.Contains(added.InitialCluster, "test-member=") {
+		t.Fatalf("initial cluster %s, want both the members", added.InitialCluster)
+	}
+	if members, err = cls.ListMembers(); err != nil || len(members) != 2 {
+		t.Fatalf("list members got %v %v, want 2", members, err)
+	}
+
+	if err = cls.PromoteMember(self); !errors.Is(err, ErrUnsafeMembership) {
+		t.Fatalf("promote the voter got %v, want unsafe", err)
+	}
+	for _, id := range []string{"ffff", "not-hex"} {
+		if err = cls.PromoteMember(id); !errors.Is(err, ErrMemberNotFound) {
+			t.Fatalf("promote %s got %v, want not found", id, err)
+		}
+		if err = cls.RemoveMember(id); !errors.Is(err, ErrMemberNotFound) {
+			t.Fatalf("remove %s got %v, want not found", id, err)
+		}
+	}
+	//refused by etcd, the learner never started to catch up.
+	if err = cls.PromoteMember(added.Member.ID); err == nil {
+		t.Fatal("promoted the learner not started")
+	}
+
+	if err = cls.RemoveMember(self); !errors.Is(err, ErrUnsafeMembership) {
+		t.Fatalf("remove the member serving got %v, want unsafe", err)
+	}
+	if err = cls.RemoveMember(added.Member.ID); err != nil {
+		t.Fatalf("remove the learner failed %v", err)
+	}
+	if members, err = cls.ListMembers(); err != nil || len(members) != 1 {
+		t.Fatalf("list members got %v %v, want 1", members, err)
+	}
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	metricIsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "cluster",
+		Name:      "is_leader",
+		Help:      "Whether the member is the leader of the embedded etcd, 1 for leader.",
+	})
+	metricMembers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "cluster",
+		Name:      "members",
+		Help:      "Number of the etcd members of the cluster.",
+	})
+	metricLeaseKeepAliveFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "cluster",
+		Name:      "lease_keepalive_failures_total",
+		Help:      "Lease keep alive failures, member for the lease of the member, instance for the leases of the instances.",
+	}, []string{"lease"})
+	metricDefragDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nmid",
+		Subsystem: "cluster",
+		Name:      "defrag_duration_seconds",
+		Help:      "Duration of the defragment.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricIsLeader, metricMembers, metricLeaseKeepAliveFailures, metricDefragDuration)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -4,30 +4,41 @@ import (
 	"context"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //cluster level mutex.
 
+//ErrLocked the mutex held by others when try lock.
+var ErrLocked = concurrency.ErrLocked
+
 type CMutex interface {
 	Lock() error
+	TryLock() error
 	Unlock() error
+	//Held the lock taken still held, false once the session of it lost, e.g. by a partition, Unlock it then.
+	Held() bool
 }
 
 type cmutex struct {
 	m       sync.Mutex
+	key     string
+	session func() (*concurrency.Session, error)
 	cm      *concurrency.Mutex
+	held    atomic.Pointer[concurrency.Session] //the session locked on, nil if not locked
 	timeout time.Duration
 }
 
+//NewCMutex the mutex on the lock session with a short ttl, the lock held by a dead node expires with the session.
 func (c *cluster) NewCMutex(key string) (CMutex, error) {
-	session, err := c.NewClusterSession()
-	if nil != err {
+	if _, err := c.lockSessionOf(); nil != err {
 		return nil, err
 	}
 
 	return &cmutex{
-		cm:      concurrency.NewMutex(session, key),
+		key:     key,
+		session: c.lockSessionOf,
 		timeout: c.requestTimeout,
 	}, nil
 }
@@ -42,19 +53,73 @@ func (cmt *cmutex) Lock() (err error) {
 		}
 	}()
 
+	//the session may have been renewed since the last lock.
+	session, err := cmt.session()
+	if nil != err {
+		cmError = false
+		return err
+	}
+	cmt.cm = concurrency.NewMutex(session, cmt.key)
+
 	ctx, cancel := context.WithTimeout(context.Background(), cmt.timeout)
 	defer cancel()
 
 	err = cmt.cm.Lock(ctx)
 	cmError = false
+	if nil == err {
+		cmt.held.Store(session)
+	}
 
 	return
 }
 
+//TryLock lock without waiting, ErrLocked if held by others, on this node or not.
+func (cmt *cmutex) TryLock() (err error) {
+	if !cmt.m.TryLock() {
+		return ErrLocked
+	}
+	defer func() {
+		if err != nil {
+			cmt.m.Unlock()
+		}
+	}()
+
+	session, err := cmt.session()
+	if nil != err {
+		return err
+	}
+	cmt.cm = concurrency.NewMutex(session, cmt.key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cmt.timeout)
+	defer cancel()
+
+	if err = cmt.cm.TryLock(ctx); nil != err {
+		return err
+	}
+	cmt.held.Store(session)
+
+	return nil
+}
+
 func (cmt *cmutex) Unlock() error {
 	ctx, cancel := context.WithTimeout(context.Background(), cmt.timeout)
 	defer cancel()
 	defer cmt.m.Unlock()
 
+	cmt.held.Store(nil)
 	return cmt.cm.Unlock(ctx)
 }
+
+func (cmt *cmutex) Held() bool {
+	session := cmt.held.Load()
+	if session == nil {
+		return false
+	}
+
+	select {
+	case <-session.Done():
+		return false
+	default:
+		return true
+	}
+}
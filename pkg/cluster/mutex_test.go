@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmbeddedClusterMutex(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+	cm, err := cls.NewCMutex("/locks/test")
+	if nil != err {
+		t.Fatalf("new mutex: %v", err)
+	}
+
+	if err = cm.TryLock(); nil != err {
+		t.Fatalf("try lock: %v", err)
+	}
+	if err = cm.TryLock(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("try lock held got %v, want locked", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cm.Lock()
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("lock not waiting for the mutex held, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err = cm.Unlock(); nil != err {
+		t.Fatalf("unlock: %v", err)
+	}
+	select {
+	case err = <-done:
+		if nil != err {
+			t.Fatalf("lock: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not taken after unlocked")
+	}
+	if err = cm.Unlock(); nil != err {
+		t.Fatalf("unlock: %v", err)
+	}
+}
+
+func TestEmbeddedClusterMutexSession(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newTestCluster(t)
+	cm, err := cls.NewCMutex("/locks/test")
+	if nil != err {
+		t.Fatalf("new mutex: %v", err)
+	}
+
+	//held on the short ttl session, not the member lease.
+	c := cls.(*cluster)
+	session, err := c.lockSessionOf()
+	if nil != err {
+		t.Fatalf("lock session: %v", err)
+	}
+	if ttl, err := cls.LeaseTimeToLive(session.Lease()); nil != err || ttl <= 0 || ttl > LockSessionTTL {
+		t.Fatalf("lock session ttl got %d %v, want (0, %d]", ttl, err, LockSessionTTL)
+	}
+
+	if cm.Held() {
+		t.Fatal("held before locked")
+	}
+	if err = cm.Lock(); nil != err {
+		t.Fatalf("lock: %v", err)
+	}
+	if !cm.Held() {
+		t.Fatal("not held after locked")
+	}
+
+	//the session lost, e.g. by a partition, the lock is not held any more and the next lock takes a new session.
+	if err = cls.RevokeLease(session.Lease()); nil != err {
+		t.Fatalf("revoke lock session: %v", err)
+	}
+	select {
+	case <-session.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock session not done after revoked")
+	}
+	if cm.Held() {
+		t.Fatal("still held after the session lost")
+	}
+	if err = cm.Unlock(); nil != err {
+		t.Fatalf("unlock the lost: %v", err)
+	}
+	if err = cm.Lock(); nil != err {
+		t.Fatalf("lock after the session lost: %v", err)
+	}
+	if renewed, _ := c.lockSessionOf(); renewed == session {
+		t.Fatal("lock session not renewed")
+	}
+	if !cm.Held() {
+		t.Fatal("not held after locked on the new session")
+	}
+	if err = cm.Unlock(); nil != err {
+		t.Fatalf("unlock: %v", err)
+	}
+	if cm.Held() {
+		t.Fatal("held after unlocked")
+	}
+}
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
@@ -38,9 +39,40 @@ func (c *cluster) PutUnderLease(key, value string) error {
 	return err
 }
 
+func (c *cluster) PutWithLease(key, value string, lease clientv3.LeaseID) error {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = func() (*clientv3.PutResponse, error) {
+		ctx, cancel := c.RequestContext()
+		defer cancel()
+		return client.Put(ctx, key, value, clientv3.WithLease(lease))
+	}()
+
+	return err
+}
+
+func (c *cluster) Delete(key string) error {
+	client, err := c.GetClusterClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+	_, err = client.Delete(ctx, key)
+
+	return err
+}
+
 func (c *cluster) Get(key string) (string, error) {
 	kv, err := c.GetRaw(key)
 	if nil != err {
+		return ``, err
+	}
+	if nil == kv {
 		return ``, nil
 	}
 
@@ -68,11 +100,44 @@ func (c *cluster) GetRaw(key string) (*mvccpb.KeyValue, error) {
 	return resp.Kvs[0], nil
 }
 
-func (c *cluster) DoWatch(key string) (chan<- WatchRet, error) {
-	watcher, err := c.NewWatcher()
+func (c *cluster) GetPrefix(prefix string) (map[string]string, error) {
+	kvs, _, err := c.GetRawPrefix(prefix)
+	if nil != err {
+		return nil, err
+	}
+
+	kvm := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		kvm[string(kv.Key)] = string(kv.Value)
+	}
+
+	return kvm, nil
+}
+
+//GetRawPrefix get the keys with the prefix, and the revision of the store when read.
+func (c *cluster) GetRawPrefix(prefix string) ([]*mvccpb.KeyValue, int64, error) {
+	client, err := c.GetClusterClient()
+	if nil != err {
+		return nil, 0, err
+	}
+
+	ctx, cancel := c.RequestContext()
+	defer cancel()
+
+	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+//DoWatch watch the key with the shared watcher until ctx done.
+func (c *cluster) DoWatch(ctx context.Context, key string, opts ...WatchOption) (<-chan WatchEvent, error) {
+	watcher, err := c.GetClusterWatcher()
 	if nil != err {
 		return nil, err
 	}
 
-	return watcher.Watch(key)
+	return watcher.Watch(ctx, key, opts...)
 }
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"fmt"
+	"nmid-registry/pkg/loger"
+)
+
+//recoverForceNewCluster make the members and their status match the single-member cluster forced to be new,
+//the server is started and the caller should hold the server mutex.
+func (c *cluster) recoverForceNewCluster() {
+	pbMembers, err := c.memberList()
+	if err != nil {
+		loger.Loger.Errorf("force-new-cluster: list members failed %v", err)
+		return
+	}
+	for _, m := range pbMembers {
+		loger.Loger.Infof("force-new-cluster: member %s(%x) peer urls %v left", m.Name, m.ID, m.PeerURLs)
+	}
+
+	if c.members != nil {
+		c.members.ResetMembers(pbMembers)
+		loger.Loger.Infof("force-new-cluster: %s rewritten", MembersFilename)
+	}
+
+	//the status of the members gone expires with their leases, drop them at once rather than waiting.
+	kvs, err := c.GetPrefix(StatusMemberPrefix)
+	if err != nil {
+		loger.Loger.Errorf("force-new-cluster: get members status failed %v", err)
+		return
+	}
+	self := fmt.Sprintf(StatusMemberFormat, c.options.Name)
+	for key := range kvs {
+		if key == self {
+			continue
+		}
+		if err := c.Delete(key); err != nil {
+			loger.Loger.Errorf("force-new-cluster: delete status %s failed %v", key, err)
+			continue
+		}
+		loger.Loger.Infof("force-new-cluster: status %s dropped", key)
+	}
+
+	loger.Loger.Warnf("force-new-cluster: recovered as a single-member cluster, restart without the flag")
+}
@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"fmt"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"gopkg.in/yaml.v2"
+	"nmid-registry/pkg/option"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedClusterForceNewCluster(t *testing.T) {
+	homeDir := t.TempDir()
+	peerUrl := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+	clientUrl := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+
+	//a member gone for good, with its status left behind.
+	cls, closeCluster := startTestCluster(t, homeDir, peerUrl, clientUrl)
+	if err := cls.Put("/test/recovery", "value"); err != nil {
+		t.Fatalf("put failed %v", err)
+	}
+	gone := fmt.Sprintf(StatusMemberFormat, "gone-member")
+	if err := cls.Put(gone, "status"); err != nil {
+		t.Fatalf("put status failed %v", err)
+	}
+	if _, err := cls.AddMember("gone-member", []string{fmt.Sprintf("http://127.0.0.1:%d", freePort(t))}, true); err != nil {
+		t.Fatalf("add member failed %v", err)
+	}
+	closeCluster()
+
+	cls, _ = startTestCluster(t, homeDir, peerUrl, clientUrl, "--force-new-cluster")
+	if value, err := cls.Get("/test/recovery"); err != nil || value != "value" {
+		t.Fatalf("get the data kept got %q %v", value, err)
+	}
+	members, err := cls.ListMembers()
+	if err != nil || len(members) != 1 || members[0].Name != "test-member" {
+		t.Fatalf("members %+v %v, want itself only", members, err)
+	}
+	if value, err := cls.Get(gone); err != nil || value != "" {
+		t.Fatalf("status of the member gone got %q %v, want dropped", value, err)
+	}
+}
+
+func TestResetMembers(t *testing.T) {
+	opt := &option.Options{
+		Name:                            "test-member",
+		ClusterRole:                     "master",
+		ClusterInitialAdvertisePeerUrls: []string{"http://127.0.0.1:2380"},
+		ClusterJoinUrls:                 []string{"http://127.0.0.2:2380", "http://127.0.0.3:2380"},
+		AbsMemberDir:                    t.TempDir(),
+	}
+	m, err := NewMembers(opt)
+	if err != nil {
+		t.Fatalf("new members failed %v", err)
+	}
+	if len(*m.KnownMembers) != 3 {
+		t.Fatalf("known members %v, want 3", *m.KnownMembers)
+	}
+
+	m.ResetMembers([]*etcdserverpb.Member{{ID: 1, Name: "test-member", PeerURLs: []string{"http://127.0.0.1:2380"}}})
+
+	//the members file rewritten too.
+	data, err := os.ReadFile(filepath.Join(opt.AbsMemberDir, MembersFilename))
+	if err != nil {
+		t.Fatalf("read members file failed %v", err)
+	}
+	stored := &Members{}
+	if err = yaml.Unmarshal(data, stored); err != nil {
+		t.Fatalf("unmarshal members file failed %v", err)
+	}
+	for _, ma := range []*membersArr{m.ClusterMembers, m.KnownMembers, stored.ClusterMembers, stored.KnownMembers} {
+		if len(*ma) != 1 || (*ma)[0].Name != "test-member" || (*ma)[0].ID != 1 {
+			t.Fatalf("members %v, want itself only", *ma)
+		}
+	}
+}
@@ -26,27 +26,48 @@ func (c *cluster) StartClusterServer() (err error) {
 		return err
 	}
 
+	if c.options.RestoreSnapshot != "" {
+		err = RestoreSnapshot(c.options, etcdConfig.InitialCluster)
+		if nil != err {
+			return err
+		}
+	}
+
+	if c.options.ForceNewCluster {
+		loger.Loger.Warnf("force-new-cluster: member %s starts as a single-member cluster keeping its data, "+
+			"all the other members removed from the membership, their status dropped and %s rewritten. "+
+			"restart without the flag once recovered, and add the other members back as new ones with empty data dirs",
+			c.options.Name, MembersFilename)
+	}
+
 	server, err := embed.StartEtcd(etcdConfig)
 	if err != nil {
 		return err
 	}
 
-	go c.EtcdServerHandle(server)
+	err = c.EtcdServerHandle(server)
+	if nil != err {
+		return err
+	}
+
+	if c.options.ForceNewCluster {
+		c.recoverForceNewCluster()
+	}
 
 	return nil
 }
 
-func (c *cluster) EtcdServerHandle(server *embed.Etcd) {
+// EtcdServerHandle wait the etcd server ready, the caller should hold the server mutex.
+func (c *cluster) EtcdServerHandle(server *embed.Etcd) error {
 	select {
 	case <-server.Server.ReadyNotify():
 		c.server = server
 		if c.server.Config().IsNewCluster() {
 			err := c.Put(NmClusterNameKey, c.options.ClusterName)
 			if err != nil {
-				err = fmt.Errorf("register cluster name %s failed: %v",
-					c.options.ClusterName, err)
-				loger.Loger.Errorf("%v", err)
-				panic(err)
+				CloseClusterServer(server)
+				c.server = nil
+				return fmt.Errorf("register cluster name %s failed: %v", c.options.ClusterName, err)
 			}
 		}
 
@@ -55,15 +76,24 @@ func (c *cluster) EtcdServerHandle(server *embed.Etcd) {
 			case err, ok := <-s.Err():
 				if ok {
 					loger.Loger.Errorf("etcd server %s serve failed: %v",
-						c.server.Config().Name, err)
+						s.Config().Name, err)
 					CloseClusterServer(s)
 				}
+			case <-c.done:
 			}
 		}(server)
 
 		loger.Loger.Infof("server is ready")
+		return nil
+	case err := <-server.Err():
+		CloseClusterServer(server)
+		return fmt.Errorf("etcd server start failed: %v", err)
 	case <-time.After(ServerTimeout):
 		CloseClusterServer(server)
+		return fmt.Errorf("etcd server not ready in %v", ServerTimeout)
+	case <-c.done:
+		CloseClusterServer(server)
+		return fmt.Errorf("cluster closed")
 	}
 }
 
@@ -93,6 +123,7 @@ func (c *cluster) CloseCluster(wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	close(c.done)
+	c.CloseClusterWatchers()
 	c.CloseClusterSession()
 	c.CloseClusterClient()
 	c.CloseServer()
@@ -42,10 +42,51 @@ func (c *cluster) NewClusterSession() (session *concurrency.Session, err error)
 	return session, nil
 }
 
+//LockSessionTTL the ttl in seconds of the lock session, the locks held by a dead node are released after it.
+const LockSessionTTL = 10
+
+//lockSessionOf get the session of the cluster mutexes, a new one if the last one expired, e.g. by a partition.
+func (c *cluster) lockSessionOf() (*concurrency.Session, error) {
+	c.sessionMutex.Lock()
+	defer c.sessionMutex.Unlock()
+
+	if c.lockSession != nil {
+		select {
+		case <-c.lockSession.Done():
+			loger.Loger.Warnf("lock session expired, renew it")
+		default:
+			return c.lockSession, nil
+		}
+	}
+
+	client, err := c.GetClusterClient()
+	if err != nil {
+		loger.Loger.Warnf("get cluster client err %v", err)
+		return nil, err
+	}
+
+	session, err := concurrency.NewSession(client, concurrency.WithTTL(LockSessionTTL))
+	if err != nil {
+		loger.Loger.Warnf("new lock session err %v", err)
+		return nil, fmt.Errorf("create lock session failed: %v", err)
+	}
+	c.lockSession = session
+
+	return session, nil
+}
+
 func (c *cluster) CloseClusterSession() {
 	c.sessionMutex.Lock()
 	defer c.sessionMutex.Unlock()
 
+	if nil != c.lockSession {
+		err := c.lockSession.Close()
+		if nil != err {
+			loger.Loger.Errorf("close lock session err %v", err)
+		}
+		c.lockSession = nil
+	}
+
 	if nil == c.session {
 		return
 	}
@@ -5,52 +5,184 @@ import (
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"nmid-registry/pkg/loger"
+	"sync"
+)
+
+const (
+	WatchChanSize = 10
 )
 
 type Watcher interface {
-	Watch(key string) (chan<- WatchRet, error)
+	Watch(ctx context.Context, key string, opts ...WatchOption) (<-chan WatchEvent, error)
+	Close()
 }
 
 type watcher struct {
 	w    clientv3.Watcher
 	done chan struct{}
+	once sync.Once
+
+	onClose func(*watcher)
+}
+
+type WatchEvent struct {
+	Type      mvccpb.Event_EventType
+	Key       string
+	Value     string
+	PrevValue string
+	Revision  int64 //mod revision of the key, the revision of deletion for delete event
+}
+
+type WatchOption func(*watchOption)
+
+type watchOption struct {
+	prefix   bool
+	revision int64
+	noPut    bool
+	noDelete bool
+}
+
+//WatchPrefix watch all the keys with the prefix.
+func WatchPrefix() WatchOption {
+	return func(o *watchOption) {
+		o.prefix = true
+	}
+}
+
+//WatchFromRevision watch from the revision, the events since then are sent first.
+func WatchFromRevision(rev int64) WatchOption {
+	return func(o *watchOption) {
+		o.revision = rev
+	}
+}
+
+//WatchNoPut filter out the put events.
+func WatchNoPut() WatchOption {
+	return func(o *watchOption) {
+		o.noPut = true
+	}
 }
 
-type WatchRet struct {
-	WType mvccpb.Event_EventType
-	WKey  string
+//WatchNoDelete filter out the delete events.
+func WatchNoDelete() WatchOption {
+	return func(o *watchOption) {
+		o.noDelete = true
+	}
 }
 
+func (o *watchOption) opOptions() []clientv3.OpOption {
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if o.prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	if o.revision > 0 {
+		opts = append(opts, clientv3.WithRev(o.revision))
+	}
+	if o.noPut {
+		opts = append(opts, clientv3.WithFilterPut())
+	}
+	if o.noDelete {
+		opts = append(opts, clientv3.WithFilterDelete())
+	}
+
+	return opts
+}
+
+//NewWatcher create a watcher tracked by the cluster, it's closed with the cluster if not closed before.
 func (c *cluster) NewWatcher() (Watcher, error) {
+	return c.newWatcher()
+}
+
+func (c *cluster) newWatcher() (*watcher, error) {
 	client, err := c.GetClusterClient()
 	if nil != err {
 		return nil, err
 	}
 
-	w := clientv3.NewWatcher(client)
-
-	return &watcher{
-		w:    w,
+	w := &watcher{
+		w:    clientv3.NewWatcher(client),
 		done: make(chan struct{}),
-	}, nil
+		onClose: func(w *watcher) {
+			c.watcherMutex.Lock()
+			defer c.watcherMutex.Unlock()
+			delete(c.watchers, w)
+		},
+	}
+
+	c.watcherMutex.Lock()
+	c.watchers[w] = struct{}{}
+	c.watcherMutex.Unlock()
+
+	return w, nil
 }
 
-func (w *watcher) Watch(key string) (chan<- WatchRet, error) {
-	//can't use context with timeout here
-	ctx, cancel := context.WithCancel(context.Background())
-	wResp := w.w.Watch(ctx, key)
+//GetClusterWatcher get the watcher shared by the DoWatch callers.
+func (c *cluster) GetClusterWatcher() (Watcher, error) {
+	c.watcherMutex.RLock()
+	if nil != c.watcher {
+		w := c.watcher
+		c.watcherMutex.RUnlock()
+		return w, nil
+	}
+	c.watcherMutex.RUnlock()
+
+	w, err := c.newWatcher()
+	if nil != err {
+		return nil, err
+	}
 
-	keyChan := make(chan<- WatchRet, 10)
+	c.watcherMutex.Lock()
+	defer c.watcherMutex.Unlock()
+	if nil != c.watcher {
+		go w.Close()
+		return c.watcher, nil
+	}
+	c.watcher = w
+
+	return w, nil
+}
+
+func (c *cluster) CloseClusterWatchers() {
+	c.watcherMutex.Lock()
+	watchers := make([]*watcher, 0, len(c.watchers))
+	for w := range c.watchers {
+		watchers = append(watchers, w)
+	}
+	c.watcher = nil
+	c.watcherMutex.Unlock()
+
+	for _, w := range watchers {
+		w.Close()
+	}
+}
+
+//Watch the key until ctx done or the watcher closed. The returned channel is closed then,
+//or when the watch canceled by the server, e.g. the revision to watch from has been compacted.
+func (w *watcher) Watch(ctx context.Context, key string, opts ...WatchOption) (<-chan WatchEvent, error) {
+	wOpt := &watchOption{}
+	for _, opt := range opts {
+		opt(wOpt)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	wResp := w.w.Watch(clientv3.WithRequireLeader(ctx), key, wOpt.opOptions()...)
+
+	eventChan := make(chan WatchEvent, WatchChanSize)
 
 	go func() {
 		defer cancel()
-		defer close(keyChan)
+		defer close(eventChan)
 
 		for {
 			select {
 			case <-w.done:
 				return
-			case resp := <-wResp:
+			case <-ctx.Done():
+				return
+			case resp, ok := <-wResp:
+				if !ok {
+					return
+				}
 				if resp.Canceled {
 					loger.Loger.Infof("watch key %s canceled: %v", key, resp.Err())
 					return
@@ -59,36 +191,47 @@ func (w *watcher) Watch(key string) (chan<- WatchRet, error) {
 					continue
 				}
 				for _, event := range resp.Events {
-					switch event.Type {
-					case mvccpb.PUT:
-						wRet := WatchRet{
-							WType: mvccpb.PUT,
-							WKey:  string(event.Kv.Value),
-						}
-						keyChan <- wRet
-					case mvccpb.DELETE:
-						wRet := WatchRet{
-							WType: mvccpb.DELETE,
-							WKey:  string(event.Kv.Value),
-						}
-						keyChan <- wRet
-					default:
+					if event.Type != mvccpb.PUT && event.Type != mvccpb.DELETE {
 						loger.Loger.Errorf("key %s received unknown event type %v", key, event.Type)
+						continue
+					}
+
+					wEvent := WatchEvent{
+						Type:     event.Type,
+						Key:      string(event.Kv.Key),
+						Value:    string(event.Kv.Value),
+						Revision: event.Kv.ModRevision,
+					}
+					if event.PrevKv != nil {
+						wEvent.PrevValue = string(event.PrevKv.Value)
+					}
+
+					select {
+					case eventChan <- wEvent:
+					case <-w.done:
+						return
+					case <-ctx.Done():
+						return
 					}
 				}
 			}
 		}
 	}()
 
-	return keyChan, nil
+	return eventChan, nil
 }
 
 func (w *watcher) Close() {
-	close(w.done)
+	w.once.Do(func() {
+		close(w.done)
 
-	err := w.w.Close()
+		err := w.w.Close()
+		if err != nil {
+			loger.Loger.Errorf("close watcher failed: %s", err.Error())
+		}
 
-	if err != nil {
-		loger.Loger.Errorf("close watcher failed: %s", err.Error())
-	}
+		if w.onClose != nil {
+			w.onClose(w)
+		}
+	})
 }
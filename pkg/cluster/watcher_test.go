@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"context"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"testing"
+	"time"
+)
+
+//newTestClientCluster a cluster holding only a client, nothing served at the endpoint.
+func newTestClientCluster(t *testing.T) *cluster {
+	t.Helper()
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{"http://127.0.0.1:0"}, DialTimeout: DialTimeout})
+	if err != nil {
+		t.Fatalf("new client failed %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	c := &cluster{
+		client:   client,
+		watchers: make(map[*watcher]struct{}),
+		done:     make(chan struct{}),
+	}
+	t.Cleanup(c.CloseClusterWatchers)
+
+	return c
+}
+
+func waitClosed(t *testing.T, events <-chan WatchEvent) {
+	t.Helper()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("event received, want closed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch not closed")
+	}
+}
+
+func TestWatchOptions(t *testing.T) {
+	wOpt := &watchOption{}
+	for _, opt := range []WatchOption{WatchPrefix(), WatchFromRevision(10), WatchNoPut()} {
+		opt(wOpt)
+	}
+
+	op := clientv3.OpGet("/test/", wOpt.opOptions()...)
+	if string(op.RangeBytes()) != clientv3.GetPrefixRangeEnd("/test/") {
+		t.Fatalf("range end %q, want the prefix end", op.RangeBytes())
+	}
+	if op.Rev() != 10 {
+		t.Fatalf("revision %d, want 10", op.Rev())
+	}
+
+	op = clientv3.OpGet("/test/a", (&watchOption{}).opOptions()...)
+	if len(op.RangeBytes()) != 0 || op.Rev() != 0 {
+		t.Fatalf("no option got range end %q revision %d, want the key only", op.RangeBytes(), op.Rev())
+	}
+}
+
+func TestCloseClusterWatchers(t *testing.T) {
+	c := newTestClientCluster(t)
+
+	//nothing served, only the canceled watch returns.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	events, err := c.DoWatch(ctx, "/test/a", WatchPrefix())
+	if err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+	waitClosed(t, events)
+	shared, err := c.GetClusterWatcher()
+	if err != nil || shared != c.watcher {
+		t.Fatalf("shared watcher got %v %v", shared, err)
+	}
+
+	w, err := c.NewWatcher()
+	if err != nil {
+		t.Fatalf("new watcher failed %v", err)
+	}
+	if n := len(c.watchers); n != 2 {
+		t.Fatalf("%d watchers tracked, want the shared and the created", n)
+	}
+
+	//closed by the owner, no longer tracked.
+	w.Close()
+	w.Close()
+	if n := len(c.watchers); n != 1 {
+		t.Fatalf("%d watchers tracked after closed, want 1", n)
+	}
+	if events, err = w.Watch(ctx, "/test/b"); err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+	waitClosed(t, events)
+
+	c.CloseClusterWatchers()
+	if len(c.watchers) != 0 || c.watcher != nil {
+		t.Fatalf("%d watchers left after the cluster closed", len(c.watchers))
+	}
+}
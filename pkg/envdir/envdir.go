@@ -33,6 +33,11 @@ func InitEnvDir(opt *option.Options) error {
 		return err
 	}
 
+	err = utils.MkdirAll(opt.AbsBackupDir)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
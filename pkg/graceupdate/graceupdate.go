@@ -0,0 +1,160 @@
+package graceupdate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/utils"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+//EnvInheritListeners the names of the listeners handed over to the child, the fds start from 3 in order.
+const EnvInheritListeners = "NMIDR_INHERIT_LISTENERS"
+
+//ErrUpgrading the child of the last upgrade still running.
+var ErrUpgrading = errors.New("upgrade child still running")
+
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+var (
+	mutex     sync.Mutex
+	listeners []*namedListener
+	child     *os.Process //the child forked, nil once it exited
+
+	inheritOnce sync.Once
+	inherited   map[string]net.Listener
+)
+
+//IsInherit report whether the process is forked by the graceful upgrade.
+func IsInherit() bool {
+	return os.Getenv(EnvInheritListeners) != ""
+}
+
+func inherit() {
+	inherited = make(map[string]net.Listener)
+
+	names := os.Getenv(EnvInheritListeners)
+	if names == "" {
+		return
+	}
+	for i, name := range strings.Split(names, ",") {
+		file := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			loger.Loger.Errorf("inherit listener %s failed %v", name, err)
+			continue
+		}
+		inherited[name] = l
+	}
+}
+
+//Listen take the listener inherited from the parent by the name, or listen on the addr,
+//the listener is handed over to the child in the next upgrade.
+func Listen(name, addr string) (net.Listener, error) {
+	inheritOnce.Do(inherit)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	l, ok := inherited[name]
+	if ok {
+		delete(inherited, name)
+		loger.Loger.Infof("listener %s on %s inherited", name, l.Addr())
+	} else {
+		var err error
+		l, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listeners = append(listeners, &namedListener{name: name, listener: l})
+
+	return l, nil
+}
+
+//ForkChild start the new binary by the same args, handing over the listeners.
+//the parent keeps serving on the listeners until the child notifies it, the child exited before that only logged.
+//ErrUpgrading if the child forked last time still running, a single child at a time.
+func ForkChild() (*os.Process, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if child != nil {
+		return nil, ErrUpgrading
+	}
+
+	names := make([]string, 0, len(listeners))
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, nl := range listeners {
+		tl, ok := nl.listener.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("listener %s not tcp", nl.name)
+		}
+		file, err := tl.File()
+		if err != nil {
+			return nil, fmt.Errorf("get file of listener %s failed: %v", nl.name, err)
+		}
+		names = append(names, nl.name)
+		files = append(files, file)
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, EnvInheritListeners+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, EnvInheritListeners+"="+strings.Join(names, ","))
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = files
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	child = cmd.Process
+
+	go func() {
+		err := cmd.Wait()
+		loger.Loger.Warnf("upgrade child %d exited %v", cmd.Process.Pid, err)
+
+		mutex.Lock()
+		child = nil
+		mutex.Unlock()
+	}()
+
+	return cmd.Process, nil
+}
+
+//NotifyParent tell the parent to close gracefully, the child took over.
+func NotifyParent() error {
+	if !IsInherit() {
+		return nil
+	}
+
+	ppid := os.Getppid()
+	loger.Loger.Infof("upgrade took over, notify parent %d to close", ppid)
+
+	return utils.RaiseSignal(ppid, utils.SignalTerm)
+}
@@ -0,0 +1,179 @@
+package graceupdate
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+//envTestChild the mode of the test binary forked as the child.
+const envTestChild = "NMIDR_TEST_CHILD"
+
+//TestChildProcess the child forked by the tests, serves one connection on the listener inherited.
+func TestChildProcess(t *testing.T) {
+	mode := os.Getenv(envTestChild)
+	if mode == "" {
+		return
+	}
+
+	l, err := Listen("test", "127.0.0.1:0")
+	if err != nil {
+		os.Exit(2)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(2)
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+
+	if mode == "takeover" {
+		if err = NotifyParent(); err != nil {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	//failed before taking over.
+	os.Exit(1)
+}
+
+//waitNoChild wait the child forked by the last test reaped.
+func waitNoChild(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mutex.Lock()
+		running := child != nil
+		mutex.Unlock()
+		if !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("child not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//forkTestChild listen and fork the test binary as the child in the mode.
+func forkTestChild(t *testing.T, mode string) (net.Listener, *os.Process) {
+	t.Helper()
+
+	waitNoChild(t)
+	mutex.Lock()
+	listeners = nil
+	mutex.Unlock()
+
+	l, err := Listen("test", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	t.Setenv(envTestChild, mode)
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestChildProcess$"}
+	defer func() { os.Args = args }()
+
+	process, err := ForkChild()
+	if err != nil {
+		t.Fatalf("fork child failed %v", err)
+	}
+
+	return l, process
+}
+
+func readFrom(t *testing.T, addr string) string {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial failed %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	data, _ := io.ReadAll(conn)
+
+	return string(data)
+}
+
+func waitExited(t *testing.T, process *os.Process) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for process.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("child not exited")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpgradeFailedBeforeTakeOver(t *testing.T) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	l, process := forkTestChild(t, "fail")
+
+	//the connection goes to the child while it is accepting on the listener inherited.
+	if got := readFrom(t, l.Addr().String()); got != "child" {
+		t.Fatalf("child served %q", got)
+	}
+	waitExited(t, process)
+
+	select {
+	case <-sigChan:
+		t.Fatal("parent closed by the child failed")
+	default:
+	}
+
+	//the parent keeps serving on the same listener.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("parent"))
+		conn.Close()
+	}()
+	if got := readFrom(t, l.Addr().String()); got != "parent" {
+		t.Fatalf("parent served %q", got)
+	}
+}
+
+func TestUpgradeTakeOver(t *testing.T) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	l, _ := forkTestChild(t, "takeover")
+	if got := readFrom(t, l.Addr().String()); got != "child" {
+		t.Fatalf("child served %q", got)
+	}
+
+	select {
+	case <-sigChan:
+	case <-time.After(10 * time.Second):
+		t.Fatal("parent not notified by the child took over")
+	}
+}
+
+func TestUpgradeIgnoredWhileChildRunning(t *testing.T) {
+	l, process := forkTestChild(t, "fail")
+
+	if _, err := ForkChild(); err != ErrUpgrading {
+		t.Fatalf("fork while the child running got %v, want upgrading", err)
+	}
+
+	if got := readFrom(t, l.Addr().String()); got != "child" {
+		t.Fatalf("child served %q", got)
+	}
+	waitExited(t, process)
+	waitNoChild(t)
+}
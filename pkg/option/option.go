@@ -37,18 +37,32 @@ type Options struct {
 	Name                     string            `yaml:"name" env:"NMIDR_NAME"`
 	Labels                   map[string]string `yaml:"labels" env:"NMIDR_LABELS"`
 	ApiAddr                  string            `yaml:"api-addr"`
+	GrpcAddr                 string            `yaml:"grpc-addr"`
 	DisableAccessLog         bool              `yaml:"disable-access-log"`
 	InitialObjectConfigFiles []string          `yaml:"initial-object-config-files"`
 	ApiTimeout               time.Duration     `yaml:"api-timeout"`
 	ApiReadTimeout           time.Duration     `yaml:"api-read-timeout"`
 	ApiWriteTimeout          time.Duration     `yaml:"api-write-timeout"`
 
+	//registry options
+	RegistryLeaseWindow      time.Duration `yaml:"registry-lease-window"`
+	RegistryEvictInterval    time.Duration `yaml:"registry-evict-interval"`
+	RegistryProtectThreshold float64       `yaml:"registry-protect-threshold"`
+	RegistryProtectTimeout   time.Duration `yaml:"registry-protect-timeout"`
+	RegistryPeers            []string      `yaml:"registry-peers"`
+
+	//backup options
+	BackupInterval  time.Duration `yaml:"backup-interval"`
+	BackupRetention int           `yaml:"backup-retention"`
+	RestoreSnapshot string        `yaml:"restore-snapshot"`
+
 	//cluster options
 	UseStandEtcd                    bool           `yaml:"use-stand-etcd"`
 	ClusterDebug                    bool           `yaml:"cluster-debug"`
 	ClusterName                     string         `yaml:"cluster-name"`
 	ClusterRole                     string         `yaml:"cluster-role"`
 	ClusterRequestTimeout           string         `yaml:"cluster-request-timeout"`
+	ClusterStartTimeout             string         `yaml:"cluster-start-timeout"`
 	ClusterListenClientUrls         []string       `yaml:"cluster-listen-client-Urls"`
 	ClusterListenPeerUrls           []string       `yaml:"cluster-listen-peer-Urls"`
 	ClusterAdvertiseClientUrls      []string       `yaml:"cluster-advertise-client-Urls"`
@@ -56,12 +70,16 @@ type Options struct {
 	ClusterJoinUrls                 []string       `yaml:"cluster-join-Urls"`
 	Cluster                         ClusterOptions `yaml:"cluster"`
 
+	//standalone etcd options, only used when use-stand-etcd.
+	StandEtcd StandEtcdOptions `yaml:"stand-etcd"`
+
 	// path
 	HomeDir   string `yaml:"home-dir"`
 	DataDir   string `yaml:"data-dir"`
 	WALDir    string `yaml:"wal-dir"`
 	LogDir    string `yaml:"log-dir"`
 	MemberDir string `yaml:"member-dir"`
+	BackupDir string `yaml:"backup-dir"`
 
 	// items below in advance
 	AbsHomeDir   string `yaml:"-"`
@@ -69,6 +87,7 @@ type Options struct {
 	AbsWALDir    string `yaml:"-"`
 	AbsLogDir    string `yaml:"-"`
 	AbsMemberDir string `yaml:"-"`
+	AbsBackupDir string `yaml:"-"`
 }
 
 // ClusterOptions defines the cluster members.
@@ -83,6 +102,16 @@ type ClusterOptions struct {
 	MaxCallSendMsgSize       int               `yaml:"max-call-send-msg-size"`
 }
 
+// StandEtcdOptions defines the connection to the external etcd cluster.
+type StandEtcdOptions struct {
+	Endpoints     []string `yaml:"endpoints"`
+	Username      string   `yaml:"username"`
+	Password      string   `yaml:"password"`
+	CertFile      string   `yaml:"cert-file"`
+	KeyFile       string   `yaml:"key-file"`
+	TrustedCAFile string   `yaml:"trusted-ca-file"`
+}
+
 func New() *Options {
 	opt := &Options{
 		flags: pflag.NewFlagSet(os.Args[0], pflag.ContinueOnError),
@@ -93,13 +122,17 @@ func New() *Options {
 	opt.flags.BoolVarP(&opt.ShowHelp, "help", "h", false, "Print the helper message and exit.")
 	opt.flags.BoolVarP(&opt.ShowConfig, "print-config", "c", false, "Print the configuration.")
 	opt.flags.StringVarP(&opt.ConfigFile, "config-file", "f", "", "Load server configuration from a file(yaml format), other command line flags will be ignored if specified.")
-	opt.flags.BoolVar(&opt.ForceNewCluster, "force-new-cluster", false, "Force to create a new one-member cluster.")
+	opt.flags.BoolVar(&opt.ForceNewCluster, "force-new-cluster", false, "Force to create a new one-member cluster keeping the data, to recover from the quorum permanently lost. Restart without it once recovered.")
 	opt.flags.BoolVar(&opt.SignalUpgrade, "signal-upgrade", false, "Send an upgrade signal to the server based on the local pid file, then exit. The original server will start a graceful upgrade after signal received.")
 	opt.flags.StringVar(&opt.Name, "name", "nmidr-default-name", "Human-readable name for this member.")
 	opt.flags.StringToStringVar(&opt.Labels, "labels", nil, "The labels for the instance of Nmid-registry.")
 	opt.flags.BoolVar(&opt.UseStandEtcd, "use-stand-etcd", false, "Use standalone etcd instead of embedded .")
 	addClusterVars(opt)
+	addStandEtcdVars(opt)
+	addRegistryVars(opt)
+	addBackupVars(opt)
 	opt.flags.StringVar(&opt.ApiAddr, "api-addr", "localhost:2381", "Address([host]:port) to listen on for administration traffic.")
+	opt.flags.StringVar(&opt.GrpcAddr, "grpc-addr", "localhost:2382", "Address([host]:port) to listen on for grpc registry traffic, empty to disable.")
 	opt.flags.BoolVar(&opt.ClusterDebug, "cluster-debug", false, "Flag to set lowest log level from INFO downgrade DEBUG.")
 	opt.flags.StringSliceVar(&opt.InitialObjectConfigFiles, "initial-object-config-files", nil, "List of configuration files for initial objects, these objects will be created at startup if not already exist.")
 
@@ -108,6 +141,7 @@ func New() *Options {
 	opt.flags.StringVar(&opt.WALDir, "wal-dir", "", "Path to the WAL directory.")
 	opt.flags.StringVar(&opt.LogDir, "log-dir", "log", "Path to the log directory.")
 	opt.flags.StringVar(&opt.MemberDir, "member-dir", "member", "Path to the member directory.")
+	opt.flags.StringVar(&opt.BackupDir, "backup-dir", "backup", "Path to the backup directory of the snapshots.")
 
 	opt.viper.BindPFlags(opt.flags)
 
@@ -118,10 +152,24 @@ func (opt *Options) YAML() string {
 	return opt.yamlStr
 }
 
+//RedactedPassword replace the credentials in the options shared with the cluster.
+const RedactedPassword = "******"
+
+//Redacted the copy of the options without the credentials, to be stored or shown to others.
+func (opt *Options) Redacted() Options {
+	redacted := *opt
+	if redacted.StandEtcd.Password != "" {
+		redacted.StandEtcd.Password = RedactedPassword
+	}
+
+	return redacted
+}
+
 func addClusterVars(opt *Options) {
 	opt.flags.StringVar(&opt.ClusterName, "cluster-name", "eg-cluster-default-name", "Human-readable name for the new cluster, ignored while joining an existed cluster.")
 	opt.flags.StringVar(&opt.ClusterRole, "cluster-role", "master", "Cluster role for this member (master, slave).")
 	opt.flags.StringVar(&opt.ClusterRequestTimeout, "cluster-request-timeout", "10s", "Timeout to handle request in the cluster.")
+	opt.flags.StringVar(&opt.ClusterStartTimeout, "cluster-start-timeout", "2m", "Timeout to wait the cluster ready at startup.")
 
 	// Deprecated: Use 'Cluster connection configuration' instead.
 	opt.flags.StringSliceVar(&opt.ClusterListenClientUrls, "cluster-listen-client-Urls", []string{"http://localhost:2379"}, "Deprecated. Use cluster.listen-client-Urls instead.")
@@ -145,6 +193,29 @@ func addClusterVars(opt *Options) {
 	opt.flags.IntVar(&opt.Cluster.MaxCallSendMsgSize, "max-call-send-msg-size", 10*1024*1024, "Maximum size in bytes for cluster synchronization messages.")
 }
 
+func addStandEtcdVars(opt *Options) {
+	opt.flags.StringSliceVar(&opt.StandEtcd.Endpoints, "stand-etcd-endpoints", nil, "List of client Urls of the standalone etcd, e.g. https://etcd-1:2379.")
+	opt.flags.StringVar(&opt.StandEtcd.Username, "stand-etcd-username", "", "Username for the standalone etcd authentication.")
+	opt.flags.StringVar(&opt.StandEtcd.Password, "stand-etcd-password", "", "Password for the standalone etcd authentication.")
+	opt.flags.StringVar(&opt.StandEtcd.CertFile, "stand-etcd-cert-file", "", "Client certificate file to connect the standalone etcd with TLS.")
+	opt.flags.StringVar(&opt.StandEtcd.KeyFile, "stand-etcd-key-file", "", "Client key file to connect the standalone etcd with TLS.")
+	opt.flags.StringVar(&opt.StandEtcd.TrustedCAFile, "stand-etcd-trusted-ca-file", "", "CA file to verify the standalone etcd with TLS.")
+}
+
+func addRegistryVars(opt *Options) {
+	opt.flags.DurationVar(&opt.RegistryLeaseWindow, "registry-lease-window", 90*time.Second, "TTL of the instance lease, instances not renewed within the window will be evicted.")
+	opt.flags.DurationVar(&opt.RegistryEvictInterval, "registry-evict-interval", 15*time.Second, "Interval to check the expiring instances, must be less than half of registry-lease-window.")
+	opt.flags.Float64Var(&opt.RegistryProtectThreshold, "registry-protect-threshold", 0.15, "Ratio(0, 1] of expired instances to enter self preservation, the expiring instances are kept alive until renewed again or the timeout, registry turns into write only meanwhile.")
+	opt.flags.DurationVar(&opt.RegistryProtectTimeout, "registry-protect-timeout", 15*time.Minute, "Longest time to keep the instances not renewing alive in self preservation, they expire after it.")
+	opt.flags.StringSliceVar(&opt.RegistryPeers, "registry-peers", nil, "List of registry api addresses of the peer zones to replicate to, in format zone=host:port, e.g. sh2=10.0.0.1:2381,sh2=10.0.0.2:2381.")
+}
+
+func addBackupVars(opt *Options) {
+	opt.flags.DurationVar(&opt.BackupInterval, "backup-interval", 1*time.Hour, "Interval to save a snapshot of the cluster data to the backup directory, 0 to disable.")
+	opt.flags.IntVar(&opt.BackupRetention, "backup-retention", 24, "Number of the latest snapshots kept in the backup directory.")
+	opt.flags.StringVar(&opt.RestoreSnapshot, "restore-snapshot", "", "Path to the snapshot to restore the data directory of this member from before the embedded etcd starts, the current data directory is moved aside.")
+}
+
 func (opt *Options) Parse() (string, error) {
 	err := opt.flags.Parse(os.Args[1:])
 	if err != nil {
@@ -193,7 +264,7 @@ func (opt *Options) Parse() (string, error) {
 	}
 
 	if opt.UseStandEtcd {
-		opt.ClusterRole = "slave" // when using external stand etcd, the cluster role cannot be "slave"
+		opt.ClusterRole = "slave" // when using external stand etcd, the cluster role can only be "slave"
 	}
 	if opt.ClusterRole == "master" && len(opt.Cluster.InitialCluster) == 0 {
 		opt.Cluster.InitialCluster = map[string]string{opt.Name: opt.Cluster.InitialAdvertisePeerUrls[0]}
@@ -239,6 +310,33 @@ func (opt *Options) GetPeerUrls() []string {
 	return peerUrls
 }
 
+// GetClientUrls get the urls for the cluster client to connect,
+// master connects to its own embedded etcd, slave connects to the masters or the standalone etcd.
+func (opt *Options) GetClientUrls() []string {
+	if opt.UseStandEtcd {
+		return opt.StandEtcd.Endpoints
+	}
+	if opt.ClusterRole == "master" {
+		if opt.IsUseInitialCluster() {
+			return opt.Cluster.AdvertiseClientUrls
+		}
+		return opt.ClusterAdvertiseClientUrls
+	}
+
+	return opt.GetPeerUrls()
+}
+
+// GetRegistryPeers get the registry api addresses of each peer zone.
+func (opt *Options) GetRegistryPeers() map[string][]string {
+	peers := make(map[string][]string)
+	for _, peer := range opt.RegistryPeers {
+		zone, addr, _ := strings.Cut(peer, "=")
+		peers[zone] = append(peers[zone], addr)
+	}
+
+	return peers
+}
+
 func (opt *Options) InitialCluster2String() string {
 	ss := make([]string, 0)
 	for name, peerUrl := range opt.Cluster.InitialCluster {
@@ -283,6 +381,18 @@ func (opt *Options) verification() error {
 		if opt.ForceNewCluster {
 			return fmt.Errorf("slave got force-new-cluster")
 		}
+		if opt.UseStandEtcd {
+			if len(opt.StandEtcd.Endpoints) == 0 {
+				return fmt.Errorf("use-stand-etcd got empty stand-etcd.endpoints")
+			}
+			if _, err := ParseUrls(opt.StandEtcd.Endpoints); err != nil {
+				return fmt.Errorf("invalid stand-etcd.endpoints %v", err)
+			}
+			if (opt.StandEtcd.CertFile == "") != (opt.StandEtcd.KeyFile == "") {
+				return fmt.Errorf("stand-etcd.cert-file and stand-etcd.key-file must be set together")
+			}
+			break
+		}
 		if len(opt.Cluster.MasterListenPeerUrls) == 0 && len(opt.ClusterJoinUrls) == 0 {
 			return fmt.Errorf("slave got empty cluster.slave-listen-peer-urls and cluster-join-urls entries")
 		}
@@ -326,6 +436,11 @@ func (opt *Options) verification() error {
 		return fmt.Errorf("invalid cluster-request-timeout %v", err)
 	}
 
+	_, err = time.ParseDuration(opt.ClusterStartTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid cluster-start-timeout %v", err)
+	}
+
 	_, _, err = net.SplitHostPort(opt.ApiAddr)
 	if err != nil {
 		return fmt.Errorf("invalid api-addr %v", err)
@@ -333,6 +448,48 @@ func (opt *Options) verification() error {
 	if err != nil {
 		return fmt.Errorf("invalid api-url %v", err)
 	}
+	if opt.GrpcAddr != "" {
+		_, _, err = net.SplitHostPort(opt.GrpcAddr)
+		if err != nil {
+			return fmt.Errorf("invalid grpc-addr %v", err)
+		}
+	}
+
+	// registry
+	if opt.RegistryLeaseWindow < 5*time.Second {
+		return fmt.Errorf("invalid registry-lease-window %v", opt.RegistryLeaseWindow)
+	}
+	if opt.RegistryEvictInterval <= 0 || opt.RegistryEvictInterval >= opt.RegistryLeaseWindow/2 {
+		return fmt.Errorf("invalid registry-evict-interval %v", opt.RegistryEvictInterval)
+	}
+	if opt.RegistryProtectThreshold <= 0 || opt.RegistryProtectThreshold > 1 {
+		return fmt.Errorf("invalid registry-protect-threshold %v", opt.RegistryProtectThreshold)
+	}
+	if opt.RegistryProtectTimeout <= 0 {
+		return fmt.Errorf("invalid registry-protect-timeout %v", opt.RegistryProtectTimeout)
+	}
+	for _, peer := range opt.RegistryPeers {
+		zone, addr, ok := strings.Cut(peer, "=")
+		if !ok || zone == "" || addr == "" {
+			return fmt.Errorf("invalid registry-peers %s", peer)
+		}
+	}
+
+	// backup
+	if opt.BackupInterval < 0 {
+		return fmt.Errorf("invalid backup-interval %v", opt.BackupInterval)
+	}
+	if opt.BackupRetention <= 0 {
+		return fmt.Errorf("invalid backup-retention %d", opt.BackupRetention)
+	}
+	if opt.RestoreSnapshot != "" {
+		if opt.ClusterRole != "master" || opt.UseStandEtcd {
+			return fmt.Errorf("restore-snapshot only for the master with embedded etcd")
+		}
+		if !utils.FileExist(opt.RestoreSnapshot) {
+			return fmt.Errorf("restore-snapshot %s not found", opt.RestoreSnapshot)
+		}
+	}
 
 	// dirs
 	if opt.HomeDir == "" {
@@ -347,6 +504,9 @@ func (opt *Options) verification() error {
 	if !opt.IsUseInitialCluster() && opt.MemberDir == "" {
 		return fmt.Errorf("empty member-dir")
 	}
+	if opt.BackupDir == "" {
+		return fmt.Errorf("empty backup-dir")
+	}
 
 	// meta
 	if opt.Name == "" {
@@ -384,6 +544,7 @@ func (opt *Options) initDir() error {
 		{dir: opt.WALDir, absDir: &opt.AbsWALDir},
 		{dir: opt.LogDir, absDir: &opt.AbsLogDir},
 		{dir: opt.MemberDir, absDir: &opt.AbsMemberDir},
+		{dir: opt.BackupDir, absDir: &opt.AbsBackupDir},
 	}
 	for _, di := range table {
 		if di.dir == "" {
@@ -0,0 +1,155 @@
+package option
+
+import (
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+//parse the options from the command line args, with the home dir in a temp dir.
+func parse(t *testing.T, args ...string) (*Options, error) {
+	t.Helper()
+
+	osArgs := os.Args
+	os.Args = append([]string{osArgs[0], "--home-dir", t.TempDir()}, args...)
+	defer func() { os.Args = osArgs }()
+
+	opt := New()
+	_, err := opt.Parse()
+
+	return opt, err
+}
+
+func TestStandEtcd(t *testing.T) {
+	endpoints := []string{"https://etcd-1:2379", "https://etcd-2:2379"}
+	opt, err := parse(t, "--use-stand-etcd", "--cluster-role", "master",
+		"--stand-etcd-endpoints", strings.Join(endpoints, ","),
+		"--stand-etcd-username", "root", "--stand-etcd-password", "secret")
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+
+	if opt.ClusterRole != "slave" {
+		t.Fatalf("cluster role %s, want slave with the standalone etcd", opt.ClusterRole)
+	}
+	if !reflect.DeepEqual(opt.GetClientUrls(), endpoints) {
+		t.Fatalf("client urls %v, want the standalone endpoints", opt.GetClientUrls())
+	}
+	if opt.StandEtcd.Username != "root" || opt.StandEtcd.Password != "secret" {
+		t.Fatalf("auth got %s %s", opt.StandEtcd.Username, opt.StandEtcd.Password)
+	}
+}
+
+func TestStandEtcdVerification(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"no endpoints", []string{"--use-stand-etcd"}},
+		{"invalid endpoint", []string{"--use-stand-etcd", "--stand-etcd-endpoints", "http://etcd-1:port"}},
+		{"cert without key", []string{"--use-stand-etcd", "--stand-etcd-endpoints", "https://etcd-1:2379", "--stand-etcd-cert-file", "client.pem"}},
+		{"key without cert", []string{"--use-stand-etcd", "--stand-etcd-endpoints", "https://etcd-1:2379", "--stand-etcd-key-file", "client-key.pem"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := parse(t, c.args...); err == nil {
+				t.Fatal("parse succeeded, want verification failed")
+			}
+		})
+	}
+}
+
+func TestRegistryPeers(t *testing.T) {
+	opt, err := parse(t, "--registry-peers", "sh2=10.0.0.1:2381,sh2=10.0.0.2:2381,sh3=10.0.1.1:2381")
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+
+	want := map[string][]string{
+		"sh2": {"10.0.0.1:2381", "10.0.0.2:2381"},
+		"sh3": {"10.0.1.1:2381"},
+	}
+	if peers := opt.GetRegistryPeers(); !reflect.DeepEqual(peers, want) {
+		t.Fatalf("registry peers %v, want %v", peers, want)
+	}
+
+	for _, peer := range []string{"10.0.0.1:2381", "=10.0.0.1:2381", "sh2="} {
+		if _, err = parse(t, "--registry-peers", peer); err == nil {
+			t.Fatalf("parse registry peer %q succeeded, want verification failed", peer)
+		}
+	}
+}
+
+func TestBackupVerification(t *testing.T) {
+	opt, err := parse(t)
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+	if opt.BackupInterval != time.Hour || opt.BackupRetention != 24 || filepath.Base(opt.AbsBackupDir) != "backup" {
+		t.Fatalf("backup options %v %d %s", opt.BackupInterval, opt.BackupRetention, opt.AbsBackupDir)
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err = os.WriteFile(snapshot, nil, 0o600); err != nil {
+		t.Fatalf("write snapshot failed %v", err)
+	}
+	if _, err = parse(t, "--restore-snapshot", snapshot); err != nil {
+		t.Fatalf("parse restore snapshot failed %v", err)
+	}
+
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"negative interval", []string{"--backup-interval", "-1s"}},
+		{"no retention", []string{"--backup-retention", "0"}},
+		{"snapshot not found", []string{"--restore-snapshot", snapshot + ".missing"}},
+		{"restore slave", []string{"--restore-snapshot", snapshot, "--use-stand-etcd", "--stand-etcd-endpoints", "https://etcd-1:2379"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := parse(t, c.args...); err == nil {
+				t.Fatal("parse succeeded, want verification failed")
+			}
+		})
+	}
+}
+
+func TestRegistryProtectTimeout(t *testing.T) {
+	opt, err := parse(t)
+	if err != nil || opt.RegistryProtectTimeout != 15*time.Minute {
+		t.Fatalf("parse got %v %v, want 15m by default", opt.RegistryProtectTimeout, err)
+	}
+	if _, err = parse(t, "--registry-protect-timeout", "0s"); err == nil {
+		t.Fatal("parse succeeded, want the zero timeout invalid")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	opt, err := parse(t, "--use-stand-etcd", "--stand-etcd-endpoints", "https://etcd-1:2379",
+		"--stand-etcd-username", "root", "--stand-etcd-password", "secret-password")
+	if err != nil {
+		t.Fatalf("parse failed %v", err)
+	}
+
+	redacted := opt.Redacted()
+	if redacted.StandEtcd.Password != RedactedPassword || redacted.StandEtcd.Username != "root" {
+		t.Fatalf("redacted auth got %s %s", redacted.StandEtcd.Username, redacted.StandEtcd.Password)
+	}
+	if opt.StandEtcd.Password != "secret-password" {
+		t.Fatal("the options redacted in place")
+	}
+	out, err := yaml.Marshal(redacted)
+	if err != nil || strings.Contains(string(out), "secret-password") {
+		t.Fatalf("yaml of the redacted options got %v, want the password out", err)
+	}
+
+	opt.StandEtcd.Password = ""
+	if opt.Redacted().StandEtcd.Password != "" {
+		t.Fatal("empty password redacted")
+	}
+}
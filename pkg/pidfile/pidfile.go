@@ -0,0 +1,44 @@
+package pidfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const PidFilename = "nmid-registry.pid"
+
+func path(homeDir string) string {
+	return filepath.Join(homeDir, PidFilename)
+}
+
+//Write the pid of the process to the home dir, the child of the upgrade overwrites it.
+func Write(homeDir string) error {
+	return os.WriteFile(path(homeDir), []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
+
+func Read(homeDir string) (int, error) {
+	data, err := os.ReadFile(path(homeDir))
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %s: %v", path(homeDir), err)
+	}
+
+	return pid, nil
+}
+
+//Remove the pid file only if it is still of the process, not taken over by the child.
+func Remove(homeDir string) {
+	pid, err := Read(homeDir)
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+
+	os.Remove(path(homeDir))
+}
@@ -0,0 +1,45 @@
+package pidfile
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestPidFile(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Read(dir); err == nil {
+		t.Fatal("read the pid file not written")
+	}
+
+	if err := Write(dir); err != nil {
+		t.Fatalf("write failed %v", err)
+	}
+	if pid, err := Read(dir); err != nil || pid != os.Getpid() {
+		t.Fatalf("read got %d %v, want %d", pid, err, os.Getpid())
+	}
+
+	//taken over by the child, kept.
+	if err := os.WriteFile(path(dir), []byte(strconv.Itoa(os.Getpid()+1)), 0o644); err != nil {
+		t.Fatalf("write the pid of the child failed %v", err)
+	}
+	Remove(dir)
+	if pid, err := Read(dir); err != nil || pid != os.Getpid()+1 {
+		t.Fatalf("pid file of the child removed, got %d %v", pid, err)
+	}
+
+	if err := Write(dir); err != nil {
+		t.Fatalf("write failed %v", err)
+	}
+	Remove(dir)
+	if _, err := os.Stat(path(dir)); !os.IsNotExist(err) {
+		t.Fatalf("pid file not removed %v", err)
+	}
+
+	if err := os.WriteFile(path(dir), []byte("pid"), 0o644); err != nil {
+		t.Fatalf("write failed %v", err)
+	}
+	if _, err := Read(dir); err == nil {
+		t.Fatal("read the invalid pid file")
+	}
+}
@@ -0,0 +1,362 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"nmid-registry/pkg/cluster"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errLeaseNotFound = errors.New("lease not found")
+
+//fakeCluster the in memory store of the registry tests, the methods not used by the registry panic.
+type fakeCluster struct {
+	cluster.Cluster
+
+	mutex      sync.Mutex
+	rev        int64
+	kvs        map[string]*mvccpb.KeyValue
+	leases     map[clientv3.LeaseID]*fakeLease
+	nextLease  clientv3.LeaseID
+	keepAlives map[clientv3.LeaseID]int
+	events     []cluster.WatchEvent
+	watches    map[*fakeWatch]struct{}
+	lockTimes  map[string]int
+	owners     map[string]*fakeMutex
+	ttlTimes   int
+}
+
+type fakeWatch struct {
+	key    string
+	prefix bool
+	ch     chan cluster.WatchEvent
+}
+
+func (fw *fakeWatch) match(key string) bool {
+	if fw.prefix {
+		return strings.HasPrefix(key, fw.key)
+	}
+
+	return key == fw.key
+}
+
+type fakeLease struct {
+	ttl      int64
+	deadline time.Time
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		kvs:        make(map[string]*mvccpb.KeyValue),
+		leases:     make(map[clientv3.LeaseID]*fakeLease),
+		keepAlives: make(map[clientv3.LeaseID]int),
+		watches:    make(map[*fakeWatch]struct{}),
+		lockTimes:  make(map[string]int),
+		owners:     make(map[string]*fakeMutex),
+	}
+}
+
+func (fc *fakeCluster) Put(key, value string) error {
+	return fc.PutWithLease(key, value, 0)
+}
+
+func (fc *fakeCluster) PutWithLease(key, value string, lease clientv3.LeaseID) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	if _, ok := fc.leases[lease]; lease != 0 && !ok {
+		return errLeaseNotFound
+	}
+	fc.rev++
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), ModRevision: fc.rev, CreateRevision: fc.rev, Lease: int64(lease)}
+	if old, ok := fc.kvs[key]; ok {
+		kv.CreateRevision = old.CreateRevision
+	}
+	fc.kvs[key] = kv
+	fc.notify(key, mvccpb.PUT)
+
+	return nil
+}
+
+func (fc *fakeCluster) Delete(key string) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.rev++
+	delete(fc.kvs, key)
+	fc.notify(key, mvccpb.DELETE)
+
+	return nil
+}
+
+func (fc *fakeCluster) Get(key string) (string, error) {
+	kv, err := fc.GetRaw(key)
+	if kv == nil {
+		return "", err
+	}
+
+	return string(kv.Value), nil
+}
+
+func (fc *fakeCluster) GetRaw(key string) (*mvccpb.KeyValue, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.kvs[key], nil
+}
+
+func (fc *fakeCluster) GetPrefix(prefix string) (map[string]string, error) {
+	kvs, _, err := fc.GetRawPrefix(prefix)
+	kvm := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		kvm[string(kv.Key)] = string(kv.Value)
+	}
+
+	return kvm, err
+}
+
+func (fc *fakeCluster) GetRawPrefix(prefix string) ([]*mvccpb.KeyValue, int64, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	var kvs []*mvccpb.KeyValue
+	for key, kv := range fc.kvs {
+		if strings.HasPrefix(key, prefix) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool {
+		return string(kvs[i].Key) < string(kvs[j].Key)
+	})
+
+	return kvs, fc.rev, nil
+}
+
+func (fc *fakeCluster) GrantLease(ttl int64) (clientv3.LeaseID, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.nextLease++
+	fc.leases[fc.nextLease] = &fakeLease{ttl: ttl, deadline: time.Now().Add(time.Duration(ttl) * time.Second)}
+
+	return fc.nextLease, nil
+}
+
+func (fc *fakeCluster) KeepAliveLeaseOnce(lease clientv3.LeaseID) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	l, ok := fc.leases[lease]
+	if !ok {
+		return errLeaseNotFound
+	}
+	l.deadline = time.Now().Add(time.Duration(l.ttl) * time.Second)
+	fc.keepAlives[lease]++
+
+	return nil
+}
+
+func (fc *fakeCluster) RevokeLease(lease clientv3.LeaseID) error {
+	fc.expire(lease)
+	return nil
+}
+
+func (fc *fakeCluster) LeaseTimeToLive(lease clientv3.LeaseID) (int64, error) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.ttlTimes++
+	l, ok := fc.leases[lease]
+	if !ok {
+		return -1, nil
+	}
+
+	return int64(time.Until(l.deadline) / time.Second), nil
+}
+
+//setTTL let the lease expire in ttl seconds, as if not kept alive for a while.
+func (fc *fakeCluster) setTTL(lease clientv3.LeaseID, ttl int64) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.leases[lease].deadline = time.Now().Add(time.Duration(ttl) * time.Second)
+}
+
+func (fc *fakeCluster) NewCMutex(key string) (cluster.CMutex, error) {
+	return &fakeMutex{fc: fc, key: key}, nil
+}
+
+//expire the lease and delete the keys attached.
+func (fc *fakeCluster) expire(lease clientv3.LeaseID) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	delete(fc.leases, lease)
+	fc.rev++
+	for key, kv := range fc.kvs {
+		if kv.Lease == int64(lease) {
+			delete(fc.kvs, key)
+			fc.notify(key, mvccpb.DELETE)
+		}
+	}
+}
+
+func (fc *fakeCluster) keepAliveTimes(lease clientv3.LeaseID) int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.keepAlives[lease]
+}
+
+//DoWatch replay the events since the revision to watch from, then the new ones until ctx done.
+func (fc *fakeCluster) DoWatch(ctx context.Context, key string, opts ...cluster.WatchOption) (<-chan cluster.WatchEvent, error) {
+	prefix, rev := watchOptions(opts)
+	fw := &fakeWatch{key: key, prefix: prefix, ch: make(chan cluster.WatchEvent, 100)}
+
+	fc.mutex.Lock()
+	for _, ev := range fc.events {
+		if rev > 0 && ev.Revision >= rev && fw.match(ev.Key) {
+			select {
+			case fw.ch <- ev:
+			default:
+			}
+		}
+	}
+	fc.watches[fw] = struct{}{}
+	fc.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		fc.mutex.Lock()
+		defer fc.mutex.Unlock()
+
+		delete(fc.watches, fw)
+		close(fw.ch)
+	}()
+
+	return fw.ch, nil
+}
+
+//watchOptions read the prefix and revision options as the cluster does, they're opaque out of the cluster package.
+func watchOptions(opts []cluster.WatchOption) (prefix bool, rev int64) {
+	for _, opt := range opts {
+		fn := reflect.ValueOf(opt)
+		o := reflect.New(fn.Type().In(0).Elem())
+		fn.Call([]reflect.Value{o})
+
+		prefix = prefix || o.Elem().FieldByName("prefix").Bool()
+		if r := o.Elem().FieldByName("revision").Int(); r > 0 {
+			rev = r
+		}
+	}
+
+	return prefix, rev
+}
+
+//notify the watches of the key changed, the caller holds the mutex.
+func (fc *fakeCluster) notify(key string, wType mvccpb.Event_EventType) {
+	ev := cluster.WatchEvent{Type: wType, Key: key, Revision: fc.rev}
+	fc.events = append(fc.events, ev)
+	for fw := range fc.watches {
+		if !fw.match(key) {
+			continue
+		}
+		select {
+		case fw.ch <- ev:
+		default:
+		}
+	}
+}
+
+func (fc *fakeCluster) watching() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return len(fc.watches)
+}
+
+func (fc *fakeCluster) leaseTimeToLiveTimes() int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.ttlTimes
+}
+
+//fakeMutex the mutex of the node, counting the locks taken, held by a single one of the nodes.
+type fakeMutex struct {
+	m   sync.Mutex
+	fc  *fakeCluster
+	key string
+}
+
+func (fm *fakeMutex) Lock() error {
+	fm.m.Lock()
+	for !fm.locked() {
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func (fm *fakeMutex) TryLock() error {
+	if !fm.m.TryLock() {
+		return cluster.ErrLocked
+	}
+	if !fm.locked() {
+		fm.m.Unlock()
+		return cluster.ErrLocked
+	}
+	return nil
+}
+
+//locked take the lock if not held by the other nodes.
+func (fm *fakeMutex) locked() bool {
+	fm.fc.mutex.Lock()
+	defer fm.fc.mutex.Unlock()
+
+	if owner, ok := fm.fc.owners[fm.key]; ok && owner != fm {
+		return false
+	}
+	fm.fc.owners[fm.key] = fm
+	fm.fc.lockTimes[fm.key]++
+	return true
+}
+
+func (fm *fakeMutex) Unlock() error {
+	fm.fc.mutex.Lock()
+	if fm.fc.owners[fm.key] == fm {
+		delete(fm.fc.owners, fm.key)
+	}
+	fm.fc.mutex.Unlock()
+
+	fm.m.Unlock()
+	return nil
+}
+
+func (fm *fakeMutex) Held() bool {
+	fm.fc.mutex.Lock()
+	defer fm.fc.mutex.Unlock()
+
+	return fm.fc.owners[fm.key] == fm
+}
+
+//loseLock as if the session of the lock holder lost.
+func (fc *fakeCluster) loseLock(key string) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	delete(fc.owners, key)
+}
+
+func (fc *fakeCluster) locked(key string) int {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.lockTimes[key]
+}
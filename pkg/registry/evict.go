@@ -0,0 +1,289 @@
+package registry
+
+import (
+	"errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/loger"
+	"strconv"
+	"time"
+)
+
+//ProtectMinExpiring a single instance going away is never taken as the network partitioned.
+const ProtectMinExpiring = 2
+
+//EvictLockKey the lock of the node running the evict rounds, only one of the nodes looks into the leases.
+const EvictLockKey = "/locks/evict"
+
+//过期实例由etcd lease自动剔除, 这里检查过期实例占比, 超过阈值时进入自我保护并续约,
+//自我保护续约的实例再次被客户端续约或超时后退出.
+func (r *Registry) DoEvict() {
+	for {
+		select {
+		case <-time.After(r.evictInterval):
+			r.RunEvict()
+		case <-r.done:
+			r.releaseEvict()
+			return
+		}
+	}
+}
+
+//RunEvict a round of the eviction, run by the node holding the evict lock only,
+//the others follow the self preservation it entered.
+func (r *Registry) RunEvict() {
+	insArr, _, err := r.instances(InstancePrefix)
+	if nil != err {
+		loger.Loger.Errorf("evict get instances failed %v", err)
+		return
+	}
+
+	r.updateMetrics(insArr)
+
+	if !r.takeEvict() {
+		r.syncProtected()
+		return
+	}
+
+	now := time.Now()
+	current := make(map[string]*Instance, len(insArr))
+	for _, ins := range insArr {
+		current[instanceKey(ins.Env, ins.ServiceId, ins.HostName)] = ins
+	}
+
+	//instances expired by the lease since last round, the keys deleted with the lease.
+	var gone []string
+	for key := range r.known {
+		if _, ok := current[key]; !ok {
+			gone = append(gone, key)
+		}
+	}
+	var logoffs map[string]string
+	if len(gone) > 0 {
+		logoffs, err = r.cluster.GetPrefix(LogOffPrefix)
+		if nil != err {
+			loger.Loger.Errorf("evict get logoffs failed %v", err)
+			return
+		}
+	}
+	var expired int
+	for _, key := range gone {
+		ins := r.known[key]
+		delete(r.protecting, key)
+		//logged off by any of the nodes, not expired, unless registered again since.
+		logOff := logOffKey(ins.Env, ins.ServiceId, ins.HostName)
+		if at, _ := strconv.ParseInt(logoffs[logOff], 10, 64); at >= ins.RegTimestamp {
+			if err := r.cluster.Delete(logOff); err != nil {
+				loger.Loger.Warnf("evict service(%s) env(%s) hostname(%s) delete logoff failed %v", ins.ServiceId, ins.Env, ins.HostName, err)
+			}
+			continue
+		}
+		metricEvictions.Inc()
+		loger.Loger.Infof("evict service(%s) env(%s) hostname(%s)", ins.ServiceId, ins.Env, ins.HostName)
+		r.touchEvicted(ins, now.UnixNano())
+		if _, ok := r.abandoned[key]; ok {
+			delete(r.abandoned, key)
+			continue
+		}
+		expired++
+	}
+	r.known = current
+
+	//nothing expired, nor in self preservation, the leases are not looked into.
+	if expired == 0 && r.protectSince.IsZero() && len(r.abandoned) == 0 {
+		r.leaveProtection()
+		return
+	}
+
+	//renewed by the clients since self preservation kept them alive.
+	var renews map[string]string
+	if len(r.protecting) > 0 {
+		renews, err = r.cluster.GetPrefix(RenewPrefix)
+		if nil != err {
+			loger.Loger.Errorf("evict get renews failed %v", err)
+			return
+		}
+	}
+
+	var stale []*Instance
+	var abandoned int
+	for _, ins := range insArr {
+		key := instanceKey(ins.Env, ins.ServiceId, ins.HostName)
+		//the static ones without lease never expire.
+		if ins.lease == 0 {
+			continue
+		}
+
+		if since, ok := r.protecting[key]; ok {
+			if renewed, _ := strconv.ParseInt(renews[renewKey(ins.Env, ins.ServiceId, ins.HostName)], 10, 64); renewed > since {
+				delete(r.protecting, key)
+				loger.Loger.Infof("protect service(%s) env(%s) hostname(%s) renewed again", ins.ServiceId, ins.Env, ins.HostName)
+			} else {
+				stale = append(stale, ins)
+			}
+			continue
+		}
+
+		ttl, err := r.cluster.LeaseTimeToLive(clientv3.LeaseID(ins.lease))
+		if nil != err {
+			loger.Loger.Errorf("evict service(%s) env(%s) hostname(%s) get lease ttl failed %v", ins.ServiceId, ins.Env, ins.HostName, err)
+			continue
+		}
+		if expireBy, ok := r.abandoned[key]; ok {
+			//not kept alive any more, expiring later than it could means renewed by the client.
+			if now.Add(time.Duration(ttl)*time.Second).UnixNano() <= expireBy {
+				abandoned++
+				continue
+			}
+			delete(r.abandoned, key)
+		}
+		//missed half of the lease window, going to expire.
+		if time.Duration(ttl)*time.Second < r.leaseWindow/2 {
+			stale = append(stale, ins)
+		}
+	}
+
+	expiring := len(stale) + expired
+	registrySize := len(insArr) - abandoned + expired
+	//too many instances expiring at once, it's more likely the network than the instances.
+	if expiring < ProtectMinExpiring || float64(expiring) <= float64(registrySize)*r.protectThreshold {
+		r.leaveProtection()
+		return
+	}
+
+	if r.protectSince.IsZero() {
+		r.protectSince = now
+		loger.Loger.Warnf("registry enter self preservation, expiring(%d) registry size(%d)", expiring, registrySize)
+	}
+	//the instances really gone should not be served forever.
+	if now.Sub(r.protectSince) > r.protectTimeout {
+		loger.Loger.Warnf("registry self preservation timeout after %v, %d instances not renewed left to expire", r.protectTimeout, len(stale))
+		for _, ins := range stale {
+			r.abandoned[instanceKey(ins.Env, ins.ServiceId, ins.HostName)] = now.Add(r.leaseWindow).UnixNano()
+		}
+		r.leaveProtection()
+		return
+	}
+	r.enterProtection()
+
+	for _, ins := range stale {
+		key := instanceKey(ins.Env, ins.ServiceId, ins.HostName)
+		if _, ok := r.protecting[key]; !ok {
+			r.protecting[key] = now.UnixNano()
+		}
+		if err := r.cluster.KeepAliveLeaseOnce(clientv3.LeaseID(ins.lease)); err != nil {
+			loger.Loger.Errorf("protect service(%s) env(%s) hostname(%s) keep alive failed %v", ins.ServiceId, ins.Env, ins.HostName, err)
+		}
+	}
+}
+
+//takeEvict report whether this node runs the evict round, the evict lock is taken once and held
+//until the node closed or the lock session lost.
+func (r *Registry) takeEvict() bool {
+	if r.evicting {
+		if r.evictLock.Held() {
+			return true
+		}
+		loger.Loger.Warnf("evict lock lost")
+		r.releaseEvict()
+	}
+
+	if r.evictLock == nil {
+		cm, err := r.cluster.NewCMutex(EvictLockKey)
+		if nil != err {
+			loger.Loger.Errorf("evict new lock failed %v", err)
+			return false
+		}
+		r.evictLock = cm
+	}
+	if err := r.evictLock.TryLock(); err != nil {
+		if !errors.Is(err, cluster.ErrLocked) {
+			loger.Loger.Errorf("evict lock failed %v", err)
+		}
+		return false
+	}
+	r.evicting = true
+
+	//taken over from another node, start over from the store.
+	r.known = make(map[string]*Instance)
+	r.protecting = make(map[string]int64)
+	r.abandoned = make(map[string]int64)
+	r.protectSince = time.Time{}
+	if since, _ := r.cluster.Get(ProtectedKey); since != "" {
+		nanos, _ := strconv.ParseInt(since, 10, 64)
+		r.protectSince = time.Unix(0, nanos)
+	}
+	loger.Loger.Infof("evict lock taken, run the evict rounds")
+
+	return true
+}
+
+func (r *Registry) releaseEvict() {
+	if !r.evicting {
+		return
+	}
+	r.evicting = false
+	if err := r.evictLock.Unlock(); err != nil {
+		loger.Loger.Warnf("evict unlock failed %v", err)
+	}
+}
+
+//syncProtected follow the self preservation entered by the node running the evict rounds.
+func (r *Registry) syncProtected() {
+	since, err := r.cluster.Get(ProtectedKey)
+	if nil != err {
+		loger.Loger.Errorf("evict get protected failed %v", err)
+		return
+	}
+	r.setProtected(since != "")
+}
+
+func (r *Registry) enterProtection() {
+	if !r.IsProtected() {
+		if err := r.cluster.Put(ProtectedKey, strconv.FormatInt(r.protectSince.UnixNano(), 10)); err != nil {
+			loger.Loger.Errorf("evict put protected failed %v", err)
+		}
+	}
+	r.setProtected(true)
+}
+
+func (r *Registry) leaveProtection() {
+	r.protectSince = time.Time{}
+	if len(r.protecting) > 0 {
+		r.protecting = make(map[string]int64)
+	}
+	if r.IsProtected() {
+		if err := r.cluster.Delete(ProtectedKey); err != nil {
+			loger.Loger.Errorf("evict delete protected failed %v", err)
+		}
+	}
+	r.setProtected(false)
+}
+
+func (r *Registry) touchEvicted(ins *Instance, now int64) {
+	unlock, err := r.lockService(ins.Env, ins.ServiceId)
+	if nil != err {
+		return
+	}
+	defer unlock()
+
+	if err = r.touchService(ins.Env, ins.ServiceId, now); err != nil {
+		loger.Loger.Errorf("evict touch service(%s) env(%s) failed %v", ins.ServiceId, ins.Env, err)
+	}
+}
+
+//IsProtected return true when registry in self preservation, the reads are refused until it left.
+func (r *Registry) IsProtected() bool {
+	return r.protected.Load()
+}
+
+func (r *Registry) setProtected(protected bool) {
+	if protected {
+		metricProtected.Set(1)
+	} else {
+		metricProtected.Set(0)
+	}
+	if r.protected.Swap(protected) && !protected {
+		loger.Loger.Infof("registry leave self preservation")
+	}
+}
@@ -0,0 +1,330 @@
+package registry
+
+import (
+	"fmt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"testing"
+	"time"
+)
+
+func registerHosts(t *testing.T, r *Registry, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		arg := testArgRegister(fmt.Sprintf("host-%d", i))
+		if err := r.Register(nil, arg, NewInstance(arg)); nil != err {
+			t.Fatalf("register: %v", err)
+		}
+	}
+}
+
+func storedInstance(t *testing.T, r *Registry, hostname string) *Instance {
+	t.Helper()
+
+	ins, err := r.instance(instanceKey("prod", "test.service", hostname))
+	if nil != err {
+		t.Fatalf("get instance %s: %v", hostname, err)
+	}
+
+	return ins
+}
+
+//stale let the lease of the instance miss half of the lease window, as if not renewed for a while.
+func stale(t *testing.T, r *Registry, hostname string) {
+	t.Helper()
+
+	r.cluster.(*fakeCluster).setTTL(clientv3.LeaseID(storedInstance(t, r, hostname).lease), 10)
+}
+
+//partition expire 2 and stale 3 of the 10 hosts, as if the network partitioned.
+func partition(t *testing.T, r *Registry) {
+	t.Helper()
+
+	fc := r.cluster.(*fakeCluster)
+	for i := 0; i < 2; i++ {
+		fc.expire(clientv3.LeaseID(storedInstance(t, r, fmt.Sprintf("host-%d", i)).lease))
+	}
+	for i := 2; i < 5; i++ {
+		stale(t, r, fmt.Sprintf("host-%d", i))
+	}
+}
+
+func countInstances(t *testing.T, r *Registry) int {
+	t.Helper()
+
+	insArr, _, err := r.instances(InstancePrefix)
+	if nil != err {
+		t.Fatalf("get instances: %v", err)
+	}
+
+	return len(insArr)
+}
+
+func TestRunEvictExpired(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	sc, err := r.service("prod", "test.service")
+	if nil != err || sc == nil {
+		t.Fatalf("get service: %v %v", sc, err)
+	}
+	fc.expire(clientv3.LeaseID(storedInstance(t, r, "host-0").lease))
+	r.RunEvict()
+
+	if r.IsProtected() {
+		t.Fatal("one expired instance of ten should not enter self preservation")
+	}
+	if n := countInstances(t, r); n != 9 {
+		t.Fatalf("expected 9 instances after the lease expired, got %d", n)
+	}
+	if _, ok := r.known[instanceKey("prod", "test.service", "host-0")]; ok {
+		t.Fatal("expired instance still known")
+	}
+	touched, err := r.service("prod", "test.service")
+	if nil != err || touched.LatestTimestamp <= sc.LatestTimestamp {
+		t.Fatalf("service latest timestamp not bumped by the eviction, %d -> %v %v", sc.LatestTimestamp, touched, err)
+	}
+}
+
+func TestRunEvictProtect(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	partition(t, r)
+	r.RunEvict()
+
+	if !r.IsProtected() {
+		t.Fatal("half of the instances expiring should enter self preservation")
+	}
+	for i := 2; i < 10; i++ {
+		ins := storedInstance(t, r, fmt.Sprintf("host-%d", i))
+		times := fc.keepAliveTimes(clientv3.LeaseID(ins.lease))
+		if i < 5 && times != 1 {
+			t.Fatalf("stale %s lease kept alive %d times in self preservation, want 1", ins.HostName, times)
+		}
+		if i >= 5 && times != 0 {
+			t.Fatalf("fresh %s lease kept alive %d times, want 0", ins.HostName, times)
+		}
+	}
+
+	for i := 2; i < 5; i++ {
+		if _, err := r.Renew(nil, testArgRenew(fmt.Sprintf("host-%d", i))); nil != err {
+			t.Fatalf("renew: %v", err)
+		}
+	}
+	r.RunEvict()
+
+	if r.IsProtected() {
+		t.Fatal("registry should leave self preservation once the instances renewed")
+	}
+}
+
+func TestRunEvictProtectExpired(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	//the expired ones count in, though the keys are gone already.
+	fc.expire(clientv3.LeaseID(storedInstance(t, r, "host-0").lease))
+	fc.expire(clientv3.LeaseID(storedInstance(t, r, "host-1").lease))
+	r.RunEvict()
+
+	if !r.IsProtected() {
+		t.Fatal("two of ten instances expired should enter self preservation")
+	}
+}
+
+func TestRunEvictLogOff(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	//the logged off ones are not expired.
+	for i := 0; i < 3; i++ {
+		if err := r.LogOff(nil, testArgLogOff(fmt.Sprintf("host-%d", i))); nil != err {
+			t.Fatalf("logoff: %v", err)
+		}
+	}
+	r.RunEvict()
+
+	if r.IsProtected() {
+		t.Fatal("logged off instances should not enter self preservation")
+	}
+	if mark, _ := r.cluster.Get(logOffKey("prod", "test.service", "host-0")); mark != "" {
+		t.Fatal("logoff mark not cleared by the evict round")
+	}
+}
+
+func TestRunEvictProtectMinExpiring(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 2)
+	r.RunEvict()
+
+	//half of a tiny registry is still a single instance going away.
+	r.cluster.(*fakeCluster).expire(clientv3.LeaseID(storedInstance(t, r, "host-0").lease))
+	r.RunEvict()
+
+	if r.IsProtected() {
+		t.Fatal("a single instance expiring should not enter self preservation")
+	}
+}
+
+func TestRunEvictProtectRenewedAgain(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	partition(t, r)
+	r.RunEvict()
+	if !r.IsProtected() || len(r.protecting) != 3 {
+		t.Fatalf("protected %v protecting %d, want the 3 stale ones", r.IsProtected(), len(r.protecting))
+	}
+
+	//kept alive by self preservation, the lease alone does not tell them renewing.
+	r.RunEvict()
+	if len(r.protecting) != 3 {
+		t.Fatalf("protecting %d, want the 3 not renewed", len(r.protecting))
+	}
+
+	if _, err := r.Renew(nil, testArgRenew("host-2")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	r.RunEvict()
+	if _, ok := r.protecting[instanceKey("prod", "test.service", "host-2")]; ok || len(r.protecting) != 2 {
+		t.Fatalf("protecting %v, want host-2 renewed left", r.protecting)
+	}
+	if !r.IsProtected() {
+		t.Fatal("registry left self preservation with 2 still not renewed")
+	}
+}
+
+func TestRunEvictProtectTimeout(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	partition(t, r)
+	r.RunEvict()
+	if !r.IsProtected() {
+		t.Fatal("half of the instances expiring should enter self preservation")
+	}
+
+	//the instances really gone are given up after the timeout.
+	r.protectSince = time.Now().Add(-r.protectTimeout - time.Second)
+	r.RunEvict()
+	if r.IsProtected() || len(r.protecting) != 0 || len(r.abandoned) != 3 {
+		t.Fatalf("protected %v protecting %d abandoned %d, want the 3 given up", r.IsProtected(), len(r.protecting), len(r.abandoned))
+	}
+
+	//expiring without keep alive, they do not turn it on again.
+	for i := 2; i < 5; i++ {
+		stale(t, r, fmt.Sprintf("host-%d", i))
+	}
+	times := fc.keepAliveTimes(clientv3.LeaseID(storedInstance(t, r, "host-3").lease))
+	r.RunEvict()
+	if r.IsProtected() {
+		t.Fatal("abandoned instances entered self preservation again")
+	}
+	if n := fc.keepAliveTimes(clientv3.LeaseID(storedInstance(t, r, "host-3").lease)); n != times {
+		t.Fatalf("abandoned lease kept alive %d times, want %d", n, times)
+	}
+
+	//the one renewed by the client a while after given up is not abandoned any more, the others expire.
+	for key := range r.abandoned {
+		r.abandoned[key] -= int64(10 * time.Second)
+	}
+	if _, err := r.Renew(nil, testArgRenew("host-2")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	r.RunEvict()
+	if _, ok := r.abandoned[instanceKey("prod", "test.service", "host-2")]; ok {
+		t.Fatal("renewed instance still abandoned")
+	}
+	for i := 3; i < 5; i++ {
+		fc.expire(clientv3.LeaseID(storedInstance(t, r, fmt.Sprintf("host-%d", i)).lease))
+	}
+	r.RunEvict()
+	if r.IsProtected() || len(r.abandoned) != 0 {
+		t.Fatalf("protected %v abandoned %d, want the expired abandoned ones not counted", r.IsProtected(), len(r.abandoned))
+	}
+	if n := countInstances(t, r); n != 6 {
+		t.Fatalf("instances %d, want 6", n)
+	}
+}
+
+func TestRunEvictLeasesUntouched(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 10)
+	r.RunEvict()
+
+	//nothing expired, the leases are not looked into one by one.
+	for i := 0; i < 5; i++ {
+		stale(t, r, fmt.Sprintf("host-%d", i))
+	}
+	r.RunEvict()
+	if n := fc.leaseTimeToLiveTimes(); n != 0 || r.IsProtected() {
+		t.Fatalf("lease ttl got %d times, protected %v, want none", n, r.IsProtected())
+	}
+
+	fc.expire(clientv3.LeaseID(storedInstance(t, r, "host-9").lease))
+	r.RunEvict()
+	if n := fc.leaseTimeToLiveTimes(); n != 9 || !r.IsProtected() {
+		t.Fatalf("lease ttl got %d times, protected %v, want the 9 left once expired", n, r.IsProtected())
+	}
+}
+
+func TestRunEvictSingleNode(t *testing.T) {
+	fc := newFakeCluster()
+	r1 := newTestRegistry(t, fc)
+	r2 := newTestRegistry(t, fc)
+	registerHosts(t, r1, 10)
+	r1.RunEvict()
+	r2.RunEvict()
+	if !r1.evicting || r2.evicting {
+		t.Fatalf("evicting %v %v, want the first node only", r1.evicting, r2.evicting)
+	}
+
+	partition(t, r1)
+	r2.RunEvict()
+	if r2.IsProtected() || fc.leaseTimeToLiveTimes() != 0 {
+		t.Fatalf("the node not holding the evict lock evicted, protected %v", r2.IsProtected())
+	}
+	r1.RunEvict()
+	r2.RunEvict()
+	if !r1.IsProtected() || !r2.IsProtected() {
+		t.Fatalf("protected %v %v, want the other node following", r1.IsProtected(), r2.IsProtected())
+	}
+
+	//the first node gone, the other takes over in self preservation.
+	fc.loseLock(EvictLockKey)
+	for i := 2; i < 5; i++ {
+		stale(t, r1, fmt.Sprintf("host-%d", i))
+	}
+	r2.RunEvict()
+	r1.RunEvict()
+	if r1.evicting || !r2.evicting {
+		t.Fatalf("evicting %v %v, want the other node taken over", r1.evicting, r2.evicting)
+	}
+	if !r2.IsProtected() || !r2.protectSince.Equal(time.Unix(0, r1.protectSince.UnixNano())) {
+		t.Fatalf("protected %v since %v, want kept on since %v", r2.IsProtected(), r2.protectSince, r1.protectSince)
+	}
+
+	for i := 2; i < 5; i++ {
+		if _, err := r1.Renew(nil, testArgRenew(fmt.Sprintf("host-%d", i))); nil != err {
+			t.Fatalf("renew: %v", err)
+		}
+	}
+	r2.RunEvict()
+	r1.RunEvict()
+	if r1.IsProtected() || r2.IsProtected() {
+		t.Fatalf("protected %v %v, want both left once renewed", r1.IsProtected(), r2.IsProtected())
+	}
+}
@@ -0,0 +1,284 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"nmid-registry/pkg/loger"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//InitialObjects the objects seeded from the initial object config files, created at startup if not exist.
+type InitialObjects struct {
+	Services   []*InitialService   `json:"services" yaml:"services"`
+	Schedulers []*InitialScheduler `json:"schedulers" yaml:"schedulers"`
+	Statuses   []*InitialStatus    `json:"statuses" yaml:"statuses"`
+}
+
+//InitialService the static service, e.g. legacy databases with fixed addrs, its instances never expire.
+type InitialService struct {
+	ServiceId   string             `json:"service_id" yaml:"service_id"`
+	Env         string             `json:"env" yaml:"env"`
+	InFlowAddr  string             `json:"inflow_addr" yaml:"inflow_addr"`
+	OutFlowAddr string             `json:"outflow_addr" yaml:"outflow_addr"`
+	Instances   []*InitialInstance `json:"instances" yaml:"instances"`
+}
+
+type InitialInstance struct {
+	Region   string            `json:"region" yaml:"region"`
+	Zone     string            `json:"zone" yaml:"zone"`
+	Hostname string            `json:"hostname" yaml:"hostname"`
+	Addrs    []string          `json:"addrs" yaml:"addrs"`
+	Version  string            `json:"version" yaml:"version"`
+	Metadata map[string]string `json:"metadata" yaml:"metadata"`
+	Weight   int64             `json:"weight" yaml:"weight"`
+	Status   uint32            `json:"status" yaml:"status"` //InstanceOk if not set
+}
+
+type InitialScheduler struct {
+	ServiceId string           `json:"service_id" yaml:"service_id"`
+	Env       string           `json:"env" yaml:"env"`
+	Zones     []*ZoneScheduler `json:"zones" yaml:"zones"`
+}
+
+//PendingOverrideTTL the seconds the status override of the instance not registered yet kept for it to register,
+//the hostname may never come, or come back long after as another one.
+const PendingOverrideTTL = 24 * 60 * 60
+
+//InitialStatus the status override of the instance, kept a while for the instance to register if not registered yet.
+type InitialStatus struct {
+	ServiceId string `json:"service_id" yaml:"service_id"`
+	Env       string `json:"env" yaml:"env"`
+	Hostname  string `json:"hostname" yaml:"hostname"`
+	Status    uint32 `json:"status" yaml:"status"`
+}
+
+//LoadInitialObjects read the initial objects from the file, json if named *.json, otherwise yaml.
+func LoadInitialObjects(file string) (*InitialObjects, error) {
+	data, err := os.ReadFile(file)
+	if nil != err {
+		return nil, err
+	}
+
+	objs := new(InitialObjects)
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		err = json.Unmarshal(data, objs)
+	} else {
+		err = yaml.UnmarshalStrict(data, objs)
+	}
+	if nil != err {
+		return nil, fmt.Errorf("decode initial objects %s failed: %v", file, err)
+	}
+	if err = objs.validate(); err != nil {
+		return nil, fmt.Errorf("invalid initial objects %s: %v", file, err)
+	}
+
+	return objs, nil
+}
+
+func (objs *InitialObjects) validate() error {
+	for _, sc := range objs.Services {
+		if sc == nil || sc.ServiceId == "" || sc.Env == "" {
+			return fmt.Errorf("service without service_id or env")
+		}
+		for _, ins := range sc.Instances {
+			if ins == nil || ins.Hostname == "" || len(ins.Addrs) == 0 {
+				return fmt.Errorf("service(%s) env(%s) instance without hostname or addrs", sc.ServiceId, sc.Env)
+			}
+			if !ValidStatus(ins.Status) {
+				return fmt.Errorf("service(%s) env(%s) hostname(%s) invalid status %d", sc.ServiceId, sc.Env, ins.Hostname, ins.Status)
+			}
+		}
+	}
+	for _, sch := range objs.Schedulers {
+		if sch == nil || sch.ServiceId == "" || sch.Env == "" || len(sch.Zones) == 0 {
+			return fmt.Errorf("scheduler without service_id, env or zones")
+		}
+		if !validZones(sch.Zones) {
+			return fmt.Errorf("service(%s) env(%s) invalid scheduler zones", sch.ServiceId, sch.Env)
+		}
+	}
+	for _, st := range objs.Statuses {
+		if st == nil || st.ServiceId == "" || st.Env == "" || st.Hostname == "" {
+			return fmt.Errorf("status without service_id, env or hostname")
+		}
+		if !ValidStatus(st.Status) {
+			return fmt.Errorf("service(%s) env(%s) hostname(%s) invalid status %d", st.ServiceId, st.Env, st.Hostname, st.Status)
+		}
+	}
+
+	return nil
+}
+
+//ImportInitialObjects create the objects not exist yet, the existing ones are left as they are,
+//so that the changes made since the last import survive restarts.
+//the caller should hold the cluster mutex to keep the members booting together from importing twice.
+func (r *Registry) ImportInitialObjects(ctx context.Context, objs *InitialObjects) error {
+	for _, sc := range objs.Services {
+		if err := r.importService(sc); err != nil {
+			return err
+		}
+	}
+	for _, sch := range objs.Schedulers {
+		if err := r.importScheduler(sch); err != nil {
+			return err
+		}
+	}
+	for _, st := range objs.Statuses {
+		if err := r.importStatus(st); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) importService(isc *InitialService) error {
+	unlock, err := r.lockService(isc.Env, isc.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now().UnixNano()
+	sc, err := r.service(isc.Env, isc.ServiceId)
+	if nil != err {
+		return err
+	}
+	if sc == nil {
+		sc = &Service{
+			ServiceId:       isc.ServiceId,
+			Env:             isc.Env,
+			InFlowAddr:      isc.InFlowAddr,
+			OutFlowAddr:     isc.OutFlowAddr,
+			LatestTimestamp: now,
+		}
+		if err = r.storeService(sc); err != nil {
+			return err
+		}
+		loger.Loger.Infof("initial service(%s) env(%s) created", isc.ServiceId, isc.Env)
+	}
+
+	var created bool
+	for _, iins := range isc.Instances {
+		key := instanceKey(isc.Env, isc.ServiceId, iins.Hostname)
+		old, err := r.instance(key)
+		if nil != err {
+			return err
+		}
+		if old != nil {
+			loger.Loger.Infof("initial service(%s) env(%s) hostname(%s) exists, skipped", isc.ServiceId, isc.Env, iins.Hostname)
+			continue
+		}
+
+		ins := &Instance{
+			ServiceId:       isc.ServiceId,
+			Region:          iins.Region,
+			Zone:            iins.Zone,
+			Env:             isc.Env,
+			HostName:        iins.Hostname,
+			Addrs:           iins.Addrs,
+			Version:         iins.Version,
+			Metadata:        iins.Metadata,
+			Weight:          iins.Weight,
+			Status:          iins.Status,
+			RegTimestamp:    now,
+			UpTimestamp:     now,
+			RenewTimestamp:  now,
+			DirtyTimestamp:  now,
+			LatestTimestamp: now,
+		}
+		if ins.Weight <= 0 {
+			ins.Weight = DefaultWeight
+		}
+		//no lease, the static instance stays until logged off.
+		if err = r.storeInstance(key, ins, 0); err != nil {
+			return err
+		}
+		created = true
+		loger.Loger.Infof("initial service(%s) env(%s) hostname(%s) created addrs %v", isc.ServiceId, isc.Env, iins.Hostname, iins.Addrs)
+	}
+	if !created {
+		return nil
+	}
+
+	return r.touchService(isc.Env, isc.ServiceId, now)
+}
+
+func (r *Registry) importScheduler(isch *InitialScheduler) error {
+	unlock, err := r.lockService(isch.Env, isch.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	sch, err := r.scheduler(isch.Env, isch.ServiceId)
+	if nil != err {
+		return err
+	}
+	if sch != nil {
+		loger.Loger.Infof("initial scheduler service(%s) env(%s) exists, skipped", isch.ServiceId, isch.Env)
+		return nil
+	}
+
+	if err = r.storeScheduler(isch.Env, isch.ServiceId, isch.Zones); err != nil {
+		return err
+	}
+	loger.Loger.Infof("initial scheduler service(%s) env(%s) created", isch.ServiceId, isch.Env)
+
+	return nil
+}
+
+//importStatus override the status of the instance registered, or keep the override for the instance to register later.
+func (r *Registry) importStatus(st *InitialStatus) error {
+	unlock, err := r.lockService(st.Env, st.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	o, err := r.override(st.Env, st.ServiceId, st.Hostname)
+	if nil != err {
+		return err
+	}
+	if o.Status != nil {
+		loger.Loger.Infof("initial status service(%s) env(%s) hostname(%s) exists, skipped", st.ServiceId, st.Env, st.Hostname)
+		return nil
+	}
+	if st.Status == InstanceOk {
+		return nil
+	}
+
+	old, err := r.instance(instanceKey(st.Env, st.ServiceId, st.Hostname))
+	if nil != err {
+		return err
+	}
+	if old != nil {
+		err = r.overrideInstance(st.Env, st.ServiceId, st.Hostname, func(ins *Instance, o *override) {
+			ins.Status = st.Status
+			o.Status = &st.Status
+		})
+		if nil != err {
+			return err
+		}
+		loger.Loger.Infof("initial status service(%s) env(%s) hostname(%s) status(%d) set", st.ServiceId, st.Env, st.Hostname, st.Status)
+		return nil
+	}
+
+	//no lease of the instance to attach to yet, the register applies it and moves it onto the lease of the instance.
+	lease, err := r.cluster.GrantLease(PendingOverrideTTL)
+	if nil != err {
+		return err
+	}
+	o.Status = &st.Status
+	if err = r.storeOverride(st.Env, st.ServiceId, st.Hostname, o, lease); err != nil {
+		return err
+	}
+	loger.Loger.Infof("initial status service(%s) env(%s) hostname(%s) status(%d) kept for the register", st.ServiceId, st.Env, st.Hostname, st.Status)
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testInitialYaml = `
+services:
+  - service_id: test.service
+    env: prod
+    instances:
+      - zone: sh1
+        hostname: db-0
+        addrs: ["tcp://10.0.0.1:3306"]
+        weight: 20
+      - zone: sh1
+        hostname: db-1
+        addrs: ["tcp://10.0.0.2:3306"]
+        status: 2
+schedulers:
+  - service_id: test.service
+    env: prod
+    zones:
+      - src: sh1
+        dst: {sh1: 80, sh2: 20}
+statuses:
+  - service_id: test.service
+    env: prod
+    hostname: host-0
+    status: 3
+  - service_id: test.service
+    env: prod
+    hostname: host-1
+    status: 1
+`
+
+func writeInitial(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o644); nil != err {
+		t.Fatalf("write %s: %v", name, err)
+	}
+
+	return file
+}
+
+func TestLoadInitialObjects(t *testing.T) {
+	objs, err := LoadInitialObjects(writeInitial(t, "initial.yaml", testInitialYaml))
+	if nil != err {
+		t.Fatalf("load yaml: %v", err)
+	}
+	if len(objs.Services) != 1 || len(objs.Services[0].Instances) != 2 || len(objs.Schedulers) != 1 || len(objs.Statuses) != 2 {
+		t.Fatalf("load yaml got %+v", objs)
+	}
+	if objs.Services[0].Instances[0].Status != InstanceOk || objs.Schedulers[0].Zones[0].Dst["sh2"] != 20 {
+		t.Fatalf("load yaml got instance %+v scheduler %+v", objs.Services[0].Instances[0], objs.Schedulers[0].Zones[0])
+	}
+
+	objs, err = LoadInitialObjects(writeInitial(t, "initial.json", `{"statuses":[{"service_id":"test.service","env":"prod","hostname":"host-0","status":1}]}`))
+	if nil != err || len(objs.Statuses) != 1 || objs.Statuses[0].Status != InstanceError {
+		t.Fatalf("load json got %+v %v", objs, err)
+	}
+
+	for name, content := range map[string]string{
+		"unknown.yaml":    "service:\n  - service_id: test.service\n",
+		"no_env.yaml":     "services:\n  - service_id: test.service\n",
+		"no_addrs.yaml":   "services:\n  - service_id: test.service\n    env: prod\n    instances:\n      - hostname: db-0\n",
+		"bad_status.yaml": "statuses:\n  - service_id: test.service\n    env: prod\n    hostname: host-0\n    status: 5\n",
+		"no_zones.yaml":   "schedulers:\n  - service_id: test.service\n    env: prod\n",
+		"bad_zones.yaml":  "schedulers:\n  - service_id: test.service\n    env: prod\n    zones:\n      - src: sh1\n        dst: {sh1: -1}\n",
+		"bad.json":        "{",
+	} {
+		if _, err = LoadInitialObjects(writeInitial(t, name, content)); nil == err {
+			t.Fatalf("load %s succeeded", name)
+		}
+	}
+	if _, err = LoadInitialObjects(filepath.Join(t.TempDir(), "missing.yaml")); nil == err {
+		t.Fatal("load the missing file succeeded")
+	}
+}
+
+func TestImportInitialObjects(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+	objs, err := LoadInitialObjects(writeInitial(t, "initial.yaml", testInitialYaml))
+	if nil != err {
+		t.Fatalf("load: %v", err)
+	}
+	if err = r.ImportInitialObjects(nil, objs); nil != err {
+		t.Fatalf("import: %v", err)
+	}
+
+	//the static instances have no lease.
+	db0, db1 := storedInstance(t, r, "db-0"), storedInstance(t, r, "db-1")
+	if db0 == nil || db0.lease != 0 || db0.Weight != 20 || db0.Status != InstanceOk {
+		t.Fatalf("static instance db-0 got %+v", db0)
+	}
+	if db1 == nil || db1.Weight != DefaultWeight || db1.Status != InstanceDraining {
+		t.Fatalf("static instance db-1 got %+v", db1)
+	}
+	sch, err := r.GetScheduler(nil, &ArgGetScheduler{ServiceId: "test.service", Env: "prod"})
+	if nil != err || sch.Zones[0].Dst["sh1"] != 80 {
+		t.Fatalf("get scheduler got %+v %v", sch, err)
+	}
+
+	//applied to the registered, kept for the one to register.
+	if ins := storedInstance(t, r, "host-0"); ins.Status != InstanceMaintenance {
+		t.Fatalf("registered host-0 status %d, want maintenance", ins.Status)
+	}
+	if o := overrideOf(t, r, "host-1"); o.Status == nil || *o.Status != InstanceError {
+		t.Fatalf("override of host-1 got %+v, want error", o)
+	}
+	//kept a while only, the hostname may never register.
+	kv, _ := r.cluster.GetRaw(overrideKey("prod", "test.service", "host-1"))
+	if ttl, _ := r.cluster.LeaseTimeToLive(clientv3.LeaseID(kv.Lease)); kv.Lease == 0 || ttl <= 0 || ttl > PendingOverrideTTL {
+		t.Fatalf("override of host-1 kept with lease %d ttl %d, want (0, %d]", kv.Lease, ttl, PendingOverrideTTL)
+	}
+	registerHosts(t, r, 2)
+	ins := storedInstance(t, r, "host-1")
+	if ins.Status != InstanceError {
+		t.Fatalf("registered host-1 status %d, want error", ins.Status)
+	}
+	//moved onto the lease of the instance once registered.
+	if kv, _ = r.cluster.GetRaw(overrideKey("prod", "test.service", "host-1")); kv.Lease != ins.lease {
+		t.Fatalf("override of host-1 on lease %d, want the lease of the instance %d", kv.Lease, ins.lease)
+	}
+
+	//the static ones never expire.
+	r.RunEvict()
+	if storedInstance(t, r, "db-0") == nil {
+		t.Fatal("static instance evicted")
+	}
+
+	//the changes made since survive the import again.
+	if err = r.SetStatus(nil, testArgSetStatus("db-0", InstanceError)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if err = r.SetScheduler(nil, &ArgSetScheduler{ServiceId: "test.service", Env: "prod", Content: `[{"src":"sh1","dst":{"sh1":50}}]`}); nil != err {
+		t.Fatalf("set scheduler: %v", err)
+	}
+	if err = r.ImportInitialObjects(nil, objs); nil != err {
+		t.Fatalf("import again: %v", err)
+	}
+	if ins = storedInstance(t, r, "db-0"); ins.Status != InstanceError {
+		t.Fatalf("static instance db-0 status %d, want error kept", ins.Status)
+	}
+	if sch, err = r.GetScheduler(nil, &ArgGetScheduler{ServiceId: "test.service", Env: "prod"}); nil != err || sch.Zones[0].Dst["sh1"] != 50 {
+		t.Fatalf("get scheduler got %+v %v, want kept", sch, err)
+	}
+
+	//the override of the static one has no lease to go with, removed by the logoff.
+	if err = r.LogOff(nil, testArgLogOff("db-0")); nil != err {
+		t.Fatalf("logoff: %v", err)
+	}
+	if o := overrideOf(t, r, "db-0"); !o.empty() {
+		t.Fatalf("override of db-0 got %+v after logoff, want removed", o)
+	}
+}
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/loger"
+	"time"
+)
+
+//the writes of a service read the instance, the override and the service then put them back,
+//the nodes writing the same service at once would overwrite each other without the service lock.
+const (
+	ServiceLockFormat    = "/locks/services/%s/%s" // +env +serviceid
+	ServiceLockRetries   = 3
+	ServiceLockRetryWait = 100 * time.Millisecond
+)
+
+//serviceMutex the cluster mutex of the service, shared by the writes of the service on this node.
+type serviceMutex struct {
+	cm   cluster.CMutex
+	refs int //the writes holding or waiting for it, dropped from the node by the last one
+}
+
+//serviceLock the cluster mutex of the service, only one per service on this node,
+//as the mutexes of the node share the session, the same key locked twice would both own it.
+//the caller must call the release returned once done with it.
+func (r *Registry) serviceLock(env, serviceId string) (cm cluster.CMutex, release func(), err error) {
+	key := fmt.Sprintf(ServiceLockFormat, env, serviceId)
+
+	r.locksMutex.Lock()
+	defer r.locksMutex.Unlock()
+
+	sm, ok := r.locks[key]
+	if !ok {
+		cm, err := r.cluster.NewCMutex(key)
+		if nil != err {
+			return nil, nil, err
+		}
+		sm = &serviceMutex{cm: cm}
+		r.locks[key] = sm
+	}
+	sm.refs++
+
+	return sm.cm, func() {
+		r.locksMutex.Lock()
+		defer r.locksMutex.Unlock()
+
+		if sm.refs--; sm.refs == 0 {
+			delete(r.locks, key)
+		}
+	}, nil
+}
+
+//lockService lock the service across the nodes, retried a few times if failed, the caller must call the unlock returned.
+func (r *Registry) lockService(env, serviceId string) (unlock func(), err error) {
+	cm, release, err := r.serviceLock(env, serviceId)
+	if nil != err {
+		return nil, err
+	}
+
+	start := time.Now()
+	err = cm.TryLock()
+	if errors.Is(err, cluster.ErrLocked) {
+		metricLockContentions.Inc()
+		err = cm.Lock()
+	}
+	for i := 0; err != nil && i < ServiceLockRetries; i++ {
+		loger.Loger.Warnf("lock service(%s) env(%s) failed %v, retry", serviceId, env, err)
+		metricLockRetries.Inc()
+		time.Sleep(ServiceLockRetryWait)
+		err = cm.Lock()
+	}
+	metricLockWait.Observe(time.Since(start).Seconds())
+	if nil != err {
+		release()
+		metricLockFailures.Inc()
+		loger.Loger.Errorf("lock service(%s) env(%s) failed %v", serviceId, env, err)
+		return nil, err
+	}
+
+	return func() {
+		if err := cm.Unlock(); err != nil {
+			loger.Loger.Errorf("unlock service(%s) env(%s) failed %v", serviceId, env, err)
+		}
+		release()
+	}, nil
+}
@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net"
+	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/envdir"
+	"nmid-registry/pkg/option"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+//lockedServices the service mutexes kept by the node.
+func lockedServices(r *Registry) int {
+	r.locksMutex.Lock()
+	defer r.locksMutex.Unlock()
+
+	return len(r.locks)
+}
+
+func TestLockService(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	key := fmt.Sprintf(ServiceLockFormat, "prod", "test.service")
+
+	//one mutex per service on the node, dropped once released by all.
+	cm, release, err := r.serviceLock("prod", "test.service")
+	if nil != err {
+		t.Fatalf("service lock: %v", err)
+	}
+	cm2, release2, _ := r.serviceLock("prod", "test.service")
+	if cm2 != cm {
+		t.Fatal("service locked by another mutex")
+	}
+	cm3, release3, _ := r.serviceLock("prod", "other.service")
+	if cm3 == cm {
+		t.Fatal("other service locked by the same mutex")
+	}
+	release()
+	release3()
+	if n := lockedServices(r); n != 1 {
+		t.Fatalf("%d service mutexes kept, want the one still referred", n)
+	}
+	release2()
+	if n := lockedServices(r); n != 0 {
+		t.Fatalf("%d service mutexes kept, want none after released", n)
+	}
+
+	//every write locks the service, the renew only reads it.
+	registerHosts(t, r, 1)
+	if _, err = r.Renew(nil, testArgRenew("host-0")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	if err = r.SetStatus(nil, testArgSetStatus("host-0", InstanceError)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if err = r.LogOff(nil, testArgLogOff("host-0")); nil != err {
+		t.Fatalf("logoff: %v", err)
+	}
+	if n := fc.locked(key); n != 3 {
+		t.Fatalf("service locked %d times, want 3", n)
+	}
+
+	//the write waits for the service locked.
+	contentions := testutil.ToFloat64(metricLockContentions)
+	unlock, err := r.lockService("prod", "test.service")
+	if nil != err {
+		t.Fatalf("lock service: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		arg := testArgRegister("host-1")
+		done <- r.Register(nil, arg, NewInstance(arg))
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("register not waiting for the service locked, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err = <-done; nil != err {
+		t.Fatalf("register: %v", err)
+	}
+	if n := testutil.ToFloat64(metricLockContentions) - contentions; n != 1 {
+		t.Fatalf("contentions counted %v, want 1", n)
+	}
+	if n := lockedServices(r); n != 0 {
+		t.Fatalf("%d service mutexes kept, want none after the writes", n)
+	}
+}
+
+func TestLockServiceConcurrent(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			arg := testArgRegister(fmt.Sprintf("host-%d", i))
+			if err := r.Register(nil, arg, NewInstance(arg)); nil != err {
+				t.Errorf("register: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := countInstances(t, r); n != 20 {
+		t.Fatalf("instances %d, want 20", n)
+	}
+	if n := lockedServices(r); n != 0 {
+		t.Fatalf("%d service mutexes kept, want none after the writes", n)
+	}
+}
+
+//newEmbeddedCluster boot a single member embedded cluster in a temp dir, the requests of it timed out in the timeout.
+func newEmbeddedCluster(t *testing.T, timeout time.Duration) cluster.Cluster {
+	t.Helper()
+
+	freeUrl := func() string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if nil != err {
+			t.Fatalf("listen: %v", err)
+		}
+		defer l.Close()
+		return "http://" + l.Addr().String()
+	}
+	peerUrl, clientUrl := freeUrl(), freeUrl()
+	args := os.Args
+	os.Args = []string{args[0],
+		"--name", "test-member",
+		"--home-dir", t.TempDir(),
+		"--cluster-request-timeout", timeout.String(),
+		"--listen-peer-Urls", peerUrl,
+		"--initial-advertise-peer-Urls", peerUrl,
+		"--listen-client-Urls", clientUrl,
+		"--advertise-client-Urls", clientUrl,
+	}
+	defer func() { os.Args = args }()
+
+	opt := option.New()
+	if _, err := opt.Parse(); nil != err {
+		t.Fatalf("parse options: %v", err)
+	}
+	if err := envdir.InitEnvDir(opt); nil != err {
+		t.Fatalf("init env dir: %v", err)
+	}
+	cls, err := cluster.NewCluster(opt)
+	if nil != err {
+		t.Fatalf("new cluster: %v", err)
+	}
+	t.Cleanup(func() {
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		cls.CloseCluster(wg)
+		wg.Wait()
+	})
+	if err = cls.WaitReady(); nil != err {
+		t.Fatalf("wait cluster ready: %v", err)
+	}
+
+	return cls
+}
+
+func TestEmbeddedLockServiceContention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("boots the embedded etcd")
+	}
+	cls := newEmbeddedCluster(t, 300*time.Millisecond)
+	r := newTestRegistry(t, cls)
+
+	//held by another node, the waiter before this node on the lock prefix.
+	lease, err := cls.GrantLease(60)
+	if nil != err {
+		t.Fatalf("grant lease: %v", err)
+	}
+	other := fmt.Sprintf(ServiceLockFormat, "prod", "test.service") + "/other"
+	if err = cls.PutWithLease(other, "", lease); nil != err {
+		t.Fatalf("put the lock of the other node: %v", err)
+	}
+
+	contentions, retries := testutil.ToFloat64(metricLockContentions), testutil.ToFloat64(metricLockRetries)
+	done := make(chan error, 1)
+	go func() {
+		arg := testArgRegister("host-0")
+		done <- r.Register(nil, arg, NewInstance(arg))
+	}()
+
+	//the lock timed out at least once before released by the other node, then taken by a retry.
+	time.Sleep(500 * time.Millisecond)
+	if err = cls.RevokeLease(lease); nil != err {
+		t.Fatalf("revoke lease: %v", err)
+	}
+	select {
+	case err = <-done:
+		if nil != err {
+			t.Fatalf("register: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("register not done after the lock released")
+	}
+	if n := testutil.ToFloat64(metricLockContentions) - contentions; n != 1 {
+		t.Fatalf("contentions counted %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(metricLockRetries) - retries; n < 1 {
+		t.Fatalf("retries counted %v, want at least 1", n)
+	}
+	if n := countInstances(t, r); n != 1 {
+		t.Fatalf("instances %d, want 1", n)
+	}
+
+	//given up after the retries while held by the other node.
+	if lease, err = cls.GrantLease(60); nil != err {
+		t.Fatalf("grant lease: %v", err)
+	}
+	if err = cls.PutWithLease(other, "", lease); nil != err {
+		t.Fatalf("put the lock of the other node: %v", err)
+	}
+	failures := testutil.ToFloat64(metricLockFailures)
+	arg := testArgRegister("host-1")
+	if err = r.Register(nil, arg, NewInstance(arg)); nil == err {
+		t.Fatal("registered while the service locked by the other node")
+	}
+	if n := testutil.ToFloat64(metricLockFailures) - failures; n != 1 {
+		t.Fatalf("failures counted %v, want 1", n)
+	}
+	if n := lockedServices(r); n != 0 {
+		t.Fatalf("%d service mutexes kept, want none after given up", n)
+	}
+}
@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"nmid-registry/pkg/loger"
+)
+
+var (
+	metricServices = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "services",
+		Help:      "Number of the services registered.",
+	})
+	metricInstances = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "instances",
+		Help:      "Number of the instances by service, zone and status.",
+	}, []string{"env", "service", "zone", "status"})
+	metricRenews = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "renews_total",
+		Help:      "Renewals of the instances handled by this node.",
+	})
+	metricEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "evictions_total",
+		Help:      "Instances evicted by the lease expired.",
+	})
+	metricWatchers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "watchers",
+		Help:      "Number of the watches waiting on this node.",
+	})
+	metricProtected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "protected",
+		Help:      "Whether the registry in self preservation, 1 for protected.",
+	})
+	metricLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "service_lock_wait_seconds",
+		Help:      "Time taken to lock the service for the writes.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1, 5},
+	})
+	metricLockContentions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "service_lock_contentions_total",
+		Help:      "Writes waited for the service locked by other writes.",
+	})
+	metricLockRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "service_lock_retries_total",
+		Help:      "Retries of locking the service after failed.",
+	})
+	metricLockFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "nmid",
+		Subsystem: "registry",
+		Name:      "service_lock_failures_total",
+		Help:      "Writes failed as the service could not be locked.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricServices, metricInstances, metricRenews, metricEvictions, metricWatchers, metricProtected,
+		metricLockWait, metricLockContentions, metricLockRetries, metricLockFailures)
+}
+
+func statusName(status uint32) string {
+	switch status {
+	case InstanceOk:
+		return "up"
+	case InstanceError:
+		return "down"
+	case InstanceDraining:
+		return "draining"
+	case InstanceMaintenance:
+		return "maintenance"
+	}
+
+	return "unknown"
+}
+
+//updateMetrics count the services and the instances, called by every evict round.
+func (r *Registry) updateMetrics(insArr []*Instance) {
+	services, err := r.cluster.GetPrefix(ServicePrefix)
+	if nil != err {
+		loger.Loger.Errorf("metrics get services failed %v", err)
+	} else {
+		metricServices.Set(float64(len(services)))
+	}
+
+	metricInstances.Reset()
+	for _, ins := range insArr {
+		metricInstances.WithLabelValues(ins.Env, ins.ServiceId, ins.Zone, statusName(ins.Status)).Inc()
+	}
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 3)
+	if err := r.SetStatus(nil, testArgSetStatus("host-0", InstanceMaintenance)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+
+	renews := testutil.ToFloat64(metricRenews)
+	if _, err := r.Renew(nil, testArgRenew("host-1")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	if n := testutil.ToFloat64(metricRenews) - renews; n != 1 {
+		t.Fatalf("renews counted %v, want 1", n)
+	}
+
+	r.RunEvict()
+	if n := testutil.ToFloat64(metricServices); n != 1 {
+		t.Fatalf("services %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(metricInstances.WithLabelValues("prod", "test.service", "sh1", "up")); n != 2 {
+		t.Fatalf("instances up %v, want 2", n)
+	}
+	if n := testutil.ToFloat64(metricInstances.WithLabelValues("prod", "test.service", "sh1", "maintenance")); n != 1 {
+		t.Fatalf("instances maintenance %v, want 1", n)
+	}
+	if n := testutil.ToFloat64(metricProtected); n != 0 {
+		t.Fatalf("protected %v, want 0", n)
+	}
+}
+
+func TestStatusName(t *testing.T) {
+	names := map[uint32]string{
+		InstanceOk:              "up",
+		InstanceError:           "down",
+		InstanceDraining:        "draining",
+		InstanceMaintenance:     "maintenance",
+		InstanceMaintenance + 1: "unknown",
+	}
+	for status, name := range names {
+		if got := statusName(status); got != name {
+			t.Fatalf("status name of %d got %s, want %s", status, got, name)
+		}
+	}
+}
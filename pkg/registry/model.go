@@ -8,10 +8,11 @@ type ArgRegister struct {
 	Zone            string   `form:"zone" binding:"required"`
 	Env             string   `form:"env" binding:"required"`
 	Hostname        string   `form:"hostname" binding:"required"`
-	Status          uint32   `form:"status" binding:"required"`
+	Status          uint32   `form:"status"` //default InstanceOk
 	Addrs           []string `form:"addrs"` //validate:"gt=0"
 	Version         string   `form:"version"`
 	Metadata        string   `form:"metadata"`
+	Weight          int64    `form:"weight"` //default DefaultWeight
 	LatestTimestamp int64    `form:"latest_timestamp"`
 	DirtyTimestamp  int64    `form:"dirty_timestamp"`
 	FromZone        bool     `form:"from_zone"`
@@ -24,7 +25,7 @@ type ArgRenew struct {
 	Zone           string `form:"zone" validate:"required"`
 	Env            string `form:"env" validate:"required"`
 	Hostname       string `form:"hostname" validate:"required"`
-	Status         uint32 `form:"status" validate:"required"`
+	Status         uint32 `form:"status"`
 	DirtyTimestamp int64  `form:"dirty_timestamp"`
 	FromZone       bool   `form:"from_zone"`
 }
@@ -40,8 +41,44 @@ type ArgLogOff struct {
 
 type ArgFetchAll struct {
 	ServiceId string `form:"service_id" binding:"required"`
+	Region    string `form:"region"`
+	Zone      string `form:"zone"`
+	Env       string `form:"env" binding:"required"`
+	Version   string `form:"version"`
+	Status    uint32 `form:"status"` //bits of the statuses, 1<<status each, default InstanceOk only
+
+	//the latest timestamp caller got, not modified returned if nothing changed since then.
+	LatestTimestamp int64 `form:"latest_timestamp"`
 }
 
 type ArgDoWatch struct {
+	ServiceIds []string `form:"service_id" binding:"required"`
+	Env        string   `form:"env" binding:"required"`
+	Revision   int64    `form:"revision"` //the revision caller got, 0 to get the current instances at once
+}
+
+type ArgSetStatus struct {
+	ServiceId string `form:"service_id" binding:"required"`
+	Env       string `form:"env" binding:"required"`
+	Hostname  string `form:"hostname" binding:"required"`
+	Status    uint32 `form:"status"` //InstanceOk to remove the override
+}
+
+type ArgSetWeight struct {
+	ServiceId string `form:"service_id" binding:"required"`
+	Env       string `form:"env" binding:"required"`
+	Hostname  string `form:"hostname" binding:"required"`
+	Weight    int64  `form:"weight"`
+	Reset     bool   `form:"reset"` //remove the override, restore the weight registered
+}
+
+type ArgSetScheduler struct {
+	ServiceId string `form:"service_id" binding:"required"`
+	Env       string `form:"env" binding:"required"`
+	Content   string `form:"content"` //json of the zone schedulers, e.g. [{"src":"sh1","dst":{"sh1":80,"sh2":20}}]
+}
+
+type ArgGetScheduler struct {
 	ServiceId string `form:"service_id" binding:"required"`
+	Env       string `form:"env" binding:"required"`
 }
@@ -0,0 +1,114 @@
+package registry
+
+import (
+	"encoding/json"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"nmid-registry/pkg/loger"
+	"time"
+)
+
+//override the status and weight of the instance set by operators. it is attached to the lease of the instance,
+//so it survives the renewals and the registering again of the instance, and gone with the instance.
+type override struct {
+	Status    *uint32 `json:"status,omitempty"`
+	Weight    *int64  `json:"weight,omitempty"`
+	RegWeight int64   `json:"reg_weight,omitempty"` //the weight registered, restored when the weight override reset
+}
+
+func (o *override) empty() bool {
+	return o.Status == nil && o.Weight == nil
+}
+
+//apply the override to the instance registering, and remember the weight it registered with.
+func (o *override) apply(ins *Instance) {
+	if o.Status != nil {
+		ins.Status = *o.Status
+	}
+	if o.Weight != nil {
+		o.RegWeight = ins.Weight
+		ins.Weight = *o.Weight
+	}
+}
+
+//override get the override of the instance, empty if none.
+func (r *Registry) override(env, serviceId, hostname string) (*override, error) {
+	o := new(override)
+	val, err := r.cluster.Get(overrideKey(env, serviceId, hostname))
+	if nil != err || val == "" {
+		return o, err
+	}
+
+	err = json.Unmarshal([]byte(val), o)
+	if nil != err {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+//storeOverride put the override under the lease of the instance, delete it if empty.
+func (r *Registry) storeOverride(env, serviceId, hostname string, o *override, lease clientv3.LeaseID) error {
+	key := overrideKey(env, serviceId, hostname)
+	if o.empty() {
+		return r.cluster.Delete(key)
+	}
+
+	val, err := json.Marshal(o)
+	if nil != err {
+		return err
+	}
+
+	return r.cluster.PutWithLease(key, string(val), lease)
+}
+
+//overrideInstance change the instance by the operator, and publish it as a change of the service,
+//the caller holds the service lock.
+func (r *Registry) overrideInstance(env, serviceId, hostname string, change func(ins *Instance, o *override)) error {
+	key := instanceKey(env, serviceId, hostname)
+	ins, err := r.instance(key)
+	if nil != err {
+		return err
+	}
+	if ins == nil {
+		loger.Loger.Warnf("override service(%s) env(%s) hostname(%s) not found", serviceId, env, hostname)
+		return ecode.NothingFound
+	}
+	o, err := r.override(env, serviceId, hostname)
+	if nil != err {
+		return err
+	}
+
+	change(ins, o)
+
+	lease := clientv3.LeaseID(ins.lease)
+	err = r.storeOverride(env, serviceId, hostname, o, lease)
+	if nil != err {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	ins.DirtyTimestamp = now
+	ins.LatestTimestamp = now
+	err = r.storeInstance(key, ins, lease)
+	if nil != err {
+		return err
+	}
+
+	err = r.touchService(env, serviceId, now)
+	if nil != err {
+		return err
+	}
+	//the peer zones take the change as registered.
+	if len(r.peers) > 0 {
+		sc, err := r.service(env, serviceId)
+		if nil != err {
+			return err
+		}
+		if sc != nil {
+			r.replicate(ReplicateRegister, ins, sc.InFlowAddr, sc.OutFlowAddr)
+		}
+	}
+
+	return nil
+}
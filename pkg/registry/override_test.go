@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestOverrideApply(t *testing.T) {
+	weight := int64(0)
+	maintenance, draining := uint32(InstanceMaintenance), uint32(InstanceDraining)
+	cases := []struct {
+		name       string
+		o          override
+		wantStatus uint32
+		wantWeight int64
+		wantReg    int64
+	}{
+		{"empty", override{}, InstanceOk, 10, 0},
+		{"status", override{Status: &maintenance}, InstanceMaintenance, 10, 0},
+		{"weight", override{Weight: &weight}, InstanceOk, 0, 10},
+		{"status and weight", override{Status: &draining, Weight: &weight}, InstanceDraining, 0, 10},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ins := &Instance{Status: InstanceOk, Weight: 10}
+			o := c.o
+			o.apply(ins)
+			if ins.Status != c.wantStatus || ins.Weight != c.wantWeight {
+				t.Fatalf("applied status %d weight %d, want %d %d", ins.Status, ins.Weight, c.wantStatus, c.wantWeight)
+			}
+			if o.RegWeight != c.wantReg {
+				t.Fatalf("registered weight %d, want %d", o.RegWeight, c.wantReg)
+			}
+		})
+	}
+
+	if !(&override{}).empty() {
+		t.Fatal("zero override not empty")
+	}
+	if (&override{Weight: &weight}).empty() {
+		t.Fatal("weight override empty")
+	}
+}
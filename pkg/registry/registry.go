@@ -1,80 +1,423 @@
 package registry
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	bm "github.com/go-kratos/kratos/pkg/net/http/blademaster"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/loger"
+	"nmid-registry/pkg/option"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+//LogOffMinTTL the seconds the logoff mark kept at least, for the evict rounds of a short interval.
+const LogOffMinTTL = 10
+
 type Registry struct {
-	lock sync.Mutex
+	cluster cluster.Cluster
 
-	servicem map[string]*Service // serviceid-env -> service
-	cluster  cluster.Cluster
-}
+	leaseWindow      time.Duration
+	evictInterval    time.Duration
+	protectThreshold float64
+	protectTimeout   time.Duration
+	protected        atomic.Bool
+
+	//owned by the evict rounds.
+	evictLock    cluster.CMutex       // held by the node running the evict rounds
+	evicting     bool                 // this node holds the evict lock
+	known        map[string]*Instance // instance key -> instance, seen by the last evict round
+	protectSince time.Time            // entered self preservation, zero if not protected
+	protecting   map[string]int64     // instance key -> kept alive by self preservation since
+	abandoned    map[string]int64     // instance key -> given up by self preservation timeout, expires by then if not renewed
+
+	peers []*peer //registries of the peer zones to replicate to
+
+	locksMutex sync.Mutex
+	locks      map[string]*serviceMutex // lock key -> cluster mutex of the service, while locked or waited
 
-type ReturnWatch struct {
-	WType int    `json:"w_type"`
-	WKey  string `json:"w_key"`
+	watchOnce sync.Once
+	watchDone chan struct{} //closed to return the watches waiting, before the servers drained
+	done      chan struct{}
 }
 
-func NewRegistry(cls cluster.Cluster) *Registry {
-	return &Registry{
-		servicem: make(map[string]*Service),
-		cluster:  cls,
+func NewRegistry(opt *option.Options, cls cluster.Cluster) *Registry {
+	r := &Registry{
+		cluster:          cls,
+		leaseWindow:      opt.RegistryLeaseWindow,
+		evictInterval:    opt.RegistryEvictInterval,
+		protectThreshold: opt.RegistryProtectThreshold,
+		protectTimeout:   opt.RegistryProtectTimeout,
+		known:            make(map[string]*Instance),
+		protecting:       make(map[string]int64),
+		abandoned:        make(map[string]int64),
+		locks:            make(map[string]*serviceMutex),
+		watchDone:        make(chan struct{}),
+		done:             make(chan struct{}),
 	}
+
+	go r.DoEvict()
+	r.startReplicate(opt.GetRegistryPeers())
+
+	return r
+}
+
+//CloseWatches return the watches waiting for changes, and the new ones at once,
+//so that the servers can be drained before the registry closed.
+func (r *Registry) CloseWatches() {
+	r.watchOnce.Do(func() {
+		close(r.watchDone)
+	})
+}
+
+func (r *Registry) Close() {
+	r.CloseWatches()
+	close(r.done)
 }
 
 //Register a new service.
-func (r *Registry) Register(c *bm.Context, arg *ArgRegister, ins *Instance) (err error) {
-	var sc *Service
+func (r *Registry) Register(ctx context.Context, arg *ArgRegister, ins *Instance) (err error) {
+	unlock, err := r.lockService(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	key := instanceKey(arg.Env, arg.ServiceId, arg.Hostname)
+	old, err := r.instance(key)
+	if nil != err {
+		return err
+	}
 
-	key := smapKey(arg.ServiceId, arg.Env)
-	r.lock.Lock()
-	sc, ok := r.servicem[key]
-	if !ok {
+	//keep the one registered with newer dirty timestamp.
+	if old != nil && old.DirtyTimestamp > ins.DirtyTimestamp {
+		ins = old
+	}
+
+	//reuse the lease of the old one if still alive, otherwise grant a new one.
+	var lease clientv3.LeaseID
+	if old != nil && old.lease != 0 {
+		lease = clientv3.LeaseID(old.lease)
+		if err := r.cluster.KeepAliveLeaseOnce(lease); err != nil {
+			lease = 0
+		}
+	}
+	if lease == 0 {
+		lease, err = r.cluster.GrantLease(r.leaseTTL())
+		if nil != err {
+			return err
+		}
+	}
+
+	//the status and weight overridden by operators win over the registered ones.
+	o, err := r.override(arg.Env, arg.ServiceId, arg.Hostname)
+	if nil != err {
+		return err
+	}
+	//stored again under the lease of the instance, the one kept for the instance to register may be on its own lease.
+	if !o.empty() {
+		o.apply(ins)
+		err = r.storeOverride(arg.Env, arg.ServiceId, arg.Hostname, o, lease)
+		if nil != err {
+			return err
+		}
+	}
+
+	err = r.storeInstance(key, ins, lease)
+	if nil != err {
+		return err
+	}
+
+	//the instance replicated keeps the timestamp of the origin, which may be older than the service,
+	//the service only moves forward, or the pollers holding a newer one would miss the change.
+	now := time.Now().UnixNano()
+	sc, err := r.service(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	if sc == nil {
 		sc = NewService(arg)
-		r.servicem[key] = sc
+		sc.LatestTimestamp = now
+		err = r.storeService(sc)
+	} else {
+		err = r.touchService(arg.Env, arg.ServiceId, now)
+	}
+	if nil != err {
+		return err
+	}
+	if !arg.FromZone {
+		r.replicate(ReplicateRegister, ins, arg.InFlowAddr, arg.OutFlowAddr)
+	}
+
+	return nil
+}
+
+//Renew refresh the heartbeat of the instance, caller should register again when the instance not found.
+//no service lock, the instance is only read, the heartbeats of the instances would contend for it.
+func (r *Registry) Renew(ctx context.Context, arg *ArgRenew) (ins *Instance, err error) {
+	key := instanceKey(arg.Env, arg.ServiceId, arg.Hostname)
+	ins, err = r.instance(key)
+	if nil != err {
+		return nil, err
+	}
+	if ins == nil {
+		loger.Loger.Warnf("renew service(%s) env(%s) hostname(%s) not found", arg.ServiceId, arg.Env, arg.Hostname)
+		return nil, ecode.NothingFound
+	}
+
+	lease := clientv3.LeaseID(ins.lease)
+	err = r.cluster.KeepAliveLeaseOnce(lease)
+	if nil != err {
+		loger.Loger.Warnf("renew service(%s) env(%s) hostname(%s) keep alive lease failed %v", arg.ServiceId, arg.Env, arg.Hostname, err)
+		return nil, ecode.NothingFound
+	}
+
+	//only the lease is kept alive, rewriting the instance on every heartbeat would wake up all the watchers.
+	ins.RenewTimestamp = time.Now().UnixNano()
+	//the evict rounds tell the instances renewing from the ones only kept alive by self preservation.
+	if r.IsProtected() {
+		err = r.cluster.PutWithLease(renewKey(arg.Env, arg.ServiceId, arg.Hostname), strconv.FormatInt(ins.RenewTimestamp, 10), lease)
+		if nil != err {
+			return nil, err
+		}
+	}
+	metricRenews.Inc()
+	if !arg.FromZone {
+		r.replicate(ReplicateRenew, ins, arg.InFlowAddr, arg.OutFlowAddr)
+	}
+
+	//the caller holds a newer instance, let it register again.
+	if arg.DirtyTimestamp > ins.DirtyTimestamp {
+		return nil, ecode.NothingFound
+	}
+	//the caller holds an older instance, return ours.
+	if arg.DirtyTimestamp > 0 && arg.DirtyTimestamp < ins.DirtyTimestamp {
+		return ins, ecode.Conflict
 	}
-	r.lock.Unlock()
 
-	sc.Instances = append(sc.Instances, ins)
+	return ins, nil
+}
+
+//LogOff remove the instance, a logoff older than the instance registered is ignored.
+func (r *Registry) LogOff(ctx context.Context, arg *ArgLogOff) (err error) {
+	unlock, err := r.lockService(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	key := instanceKey(arg.Env, arg.ServiceId, arg.Hostname)
+	ins, err := r.instance(key)
+	if nil != err {
+		return err
+	}
+	if ins == nil || ins.Zone != arg.Zone {
+		loger.Loger.Warnf("logoff service(%s) env(%s) zone(%s) hostname(%s) not found", arg.ServiceId, arg.Env, arg.Zone, arg.Hostname)
+		return ecode.NothingFound
+	}
+
+	if arg.LatestTimestamp > 0 && arg.LatestTimestamp < ins.LatestTimestamp {
+		loger.Loger.Warnf("logoff service(%s) env(%s) hostname(%s) older than registered", arg.ServiceId, arg.Env, arg.Hostname)
+		return ecode.Conflict
+	}
+
+	err = r.cluster.Delete(key)
+	if nil != err {
+		return err
+	}
+	//gone with the lease revoked too, but the static instances have none.
+	err = r.cluster.Delete(overrideKey(arg.Env, arg.ServiceId, arg.Hostname))
+	if nil != err {
+		return err
+	}
+	r.markLogOff(ins)
+	if !arg.FromZone {
+		r.replicate(ReplicateLogOff, ins, "", "")
+	}
+	if ins.lease != 0 {
+		if err := r.cluster.RevokeLease(clientv3.LeaseID(ins.lease)); err != nil {
+			loger.Loger.Warnf("logoff service(%s) env(%s) hostname(%s) revoke lease failed %v", arg.ServiceId, arg.Env, arg.Hostname, err)
+		}
+	}
+
+	return r.touchService(arg.Env, arg.ServiceId, time.Now().UnixNano())
+}
+
+//markLogOff tell the evict round the instance logged off rather than expired,
+//the mark expires by itself a couple of rounds later.
+func (r *Registry) markLogOff(ins *Instance) {
+	ttl := int64(2 * r.evictInterval / time.Second)
+	if ttl < LogOffMinTTL {
+		ttl = LogOffMinTTL
+	}
+	lease, err := r.cluster.GrantLease(ttl)
+	if nil != err {
+		loger.Loger.Warnf("logoff service(%s) env(%s) hostname(%s) grant lease failed %v", ins.ServiceId, ins.Env, ins.HostName, err)
+		return
+	}
+	err = r.cluster.PutWithLease(logOffKey(ins.Env, ins.ServiceId, ins.HostName), strconv.FormatInt(time.Now().UnixNano(), 10), lease)
+	if nil != err {
+		loger.Loger.Warnf("logoff service(%s) env(%s) hostname(%s) mark failed %v", ins.ServiceId, ins.Env, ins.HostName, err)
+	}
+}
+
+//FetchAll get the instances of the service grouped by zone,
+//return not modified if the latest timestamp of caller is still the latest.
+func (r *Registry) FetchAll(ctx context.Context, arg *ArgFetchAll) (info *InstanceInfo, err error) {
+	info, _, err = r.fetch(arg)
+
+	return info, err
+}
+
+//fetch the instances of the service and the revision of the store when read.
+func (r *Registry) fetch(arg *ArgFetchAll) (info *InstanceInfo, rev int64, err error) {
+	//the instances of a service are fetched within a single env, as the scheduler of it.
+	if arg.Env == "" {
+		return nil, 0, ecode.RequestErr
+	}
+
+	sc, err := r.service(arg.Env, arg.ServiceId)
+	if nil != err {
+		return nil, 0, err
+	}
+	if sc == nil {
+		return nil, 0, ecode.NothingFound
+	}
+	if arg.LatestTimestamp > 0 && arg.LatestTimestamp == sc.LatestTimestamp {
+		return nil, 0, ecode.NotModified
+	}
+
+	insArr, rev, err := r.instances(instancePrefix(arg.Env, arg.ServiceId))
+	if nil != err {
+		return nil, 0, err
+	}
+
+	info = &InstanceInfo{
+		Instances:       make(map[string][]*Instance),
+		LatestTimestamp: sc.LatestTimestamp,
+	}
+	for _, ins := range insArr {
+		if !ins.match(arg) {
+			continue
+		}
+		info.Instances[ins.Zone] = append(info.Instances[ins.Zone], ins)
+	}
+
+	info.Scheduler, err = r.scheduler(arg.Env, arg.ServiceId)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	return info, rev, nil
+}
+
+//service get the service from the cluster, nil if not exist.
+func (r *Registry) service(env, serviceId string) (*Service, error) {
+	val, err := r.cluster.Get(serviceKey(env, serviceId))
+	if nil != err {
+		return nil, err
+	}
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	sc := new(Service)
+	err = json.Unmarshal([]byte(val), sc)
+	if nil != err {
+		return nil, err
+	}
+
+	return sc, nil
+}
+
+func (r *Registry) storeService(sc *Service) error {
+	sc.Instances = nil
 	serviceVal, err := json.Marshal(sc)
 	if nil != err {
 		return err
 	}
 
-	//put to etcd cluster
-	return r.cluster.Put(key, string(serviceVal))
+	return r.cluster.Put(serviceKey(sc.Env, sc.ServiceId), string(serviceVal))
 }
 
-func (r *Registry) Renew(c *bm.Context, arg *ArgRenew) (ins *Instance, err error) {
+//touchService bump the latest timestamp of the service.
+func (r *Registry) touchService(env, serviceId string, latestTime int64) error {
+	sc, err := r.service(env, serviceId)
+	if nil != err || sc == nil {
+		return err
+	}
+	if sc.LatestTimestamp >= latestTime {
+		return nil
+	}
+	sc.LatestTimestamp = latestTime
 
-	return
+	return r.storeService(sc)
 }
 
-func (r *Registry) LogOff(c *bm.Context, arg *ArgLogOff) (err error) {
+//instance get the instance from the cluster, nil if not exist.
+func (r *Registry) instance(key string) (*Instance, error) {
+	kv, err := r.cluster.GetRaw(key)
+	if nil != err {
+		return nil, err
+	}
+	if kv == nil {
+		return nil, nil
+	}
 
-	return
+	return decodeInstance(kv)
 }
 
-func (r *Registry) FetchAll(c *bm.Context, arg *ArgFetchAll) (insArr []*Instance, err error) {
+//instances get all the instances under the prefix, and the revision of the store when read.
+func (r *Registry) instances(prefix string) ([]*Instance, int64, error) {
+	kvs, rev, err := r.cluster.GetRawPrefix(prefix)
+	if nil != err {
+		return nil, 0, err
+	}
+
+	insArr := make([]*Instance, 0, len(kvs))
+	for _, kv := range kvs {
+		ins, err := decodeInstance(kv)
+		if nil != err {
+			loger.Loger.Errorf("decode instance %s failed %v", kv.Key, err)
+			continue
+		}
+		insArr = append(insArr, ins)
+	}
 
-	return
+	return insArr, rev, nil
 }
 
-func (r *Registry) DoWatch(c *bm.Context, arg *ArgDoWatch) (rw ReturnWatch, err error) {
-	//wRet, err := r.cluster.DoWatch(arg.ServiceId)
-	//for ret := range wRet {
-	//	rw.WType = int(ret.WType)
-	//	rw.WKey = ret.WKey
-	//}
+func (r *Registry) storeInstance(key string, ins *Instance, lease clientv3.LeaseID) error {
+	insVal, err := json.Marshal(ins)
+	if nil != err {
+		return err
+	}
 
-	return rw, err
+	err = r.cluster.PutWithLease(key, string(insVal), lease)
+	if nil != err {
+		return err
+	}
+	ins.lease = int64(lease)
+
+	return nil
+}
+
+//leaseTTL is the ttl in seconds of the instance lease.
+func (r *Registry) leaseTTL() int64 {
+	return int64(r.leaseWindow / time.Second)
 }
 
-func smapKey(serviceId, env string) string {
-	return fmt.Sprintf("%s-%s", serviceId, env)
+func decodeInstance(kv *mvccpb.KeyValue) (*Instance, error) {
+	ins := new(Instance)
+	err := json.Unmarshal(kv.Value, ins)
+	if nil != err {
+		return nil, err
+	}
+	ins.lease = kv.Lease
+
+	return ins, nil
 }
@@ -0,0 +1,332 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"nmid-registry/pkg/cluster"
+	"nmid-registry/pkg/option"
+	"testing"
+	"time"
+)
+
+//newTestRegistry the registry on the cluster, evicting only when the test runs it.
+func newTestRegistry(t *testing.T, cls cluster.Cluster) *Registry {
+	t.Helper()
+
+	r := NewRegistry(&option.Options{
+		RegistryLeaseWindow:      90 * time.Second,
+		RegistryEvictInterval:    time.Hour,
+		RegistryProtectThreshold: 0.15,
+		RegistryProtectTimeout:   15 * time.Minute,
+	}, cls)
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func testArgRegister(hostname string) *ArgRegister {
+	return &ArgRegister{
+		ServiceId:   "test.service",
+		InFlowAddr:  "127.0.0.1:2381",
+		OutFlowAddr: "127.0.0.1:2381",
+		Zone:        "sh1",
+		Env:         "prod",
+		Hostname:    hostname,
+		Status:      InstanceOk,
+		Addrs:       []string{"grpc://127.0.0.1:9000"},
+	}
+}
+
+func testArgRenew(hostname string) *ArgRenew {
+	return &ArgRenew{
+		ServiceId: "test.service",
+		Zone:      "sh1",
+		Env:       "prod",
+		Hostname:  hostname,
+		Status:    InstanceOk,
+	}
+}
+
+func TestRenew(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	arg := testArgRegister("host0")
+	reg := NewInstance(arg)
+	if err := r.Register(nil, arg, reg); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	ins, err := r.Renew(nil, testArgRenew("host0"))
+	if err != nil {
+		t.Fatalf("renew failed %v", err)
+	}
+	if ins.RenewTimestamp <= reg.RegTimestamp {
+		t.Fatalf("renew timestamp %d not refreshed, registered at %d", ins.RenewTimestamp, reg.RegTimestamp)
+	}
+
+	if _, err = r.Renew(nil, testArgRenew("host1")); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("renew hostname not registered got %v, want nothing found", err)
+	}
+	other := testArgRenew("host0")
+	other.ServiceId = "other.service"
+	if _, err = r.Renew(nil, other); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("renew service not registered got %v, want nothing found", err)
+	}
+}
+
+func TestRenewOnlyKeepsAlive(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	arg := testArgRegister("host0")
+	if err := r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	key := instanceKey("prod", "test.service", "host0")
+	before, _ := fc.GetRaw(key)
+	if _, err := r.Renew(nil, testArgRenew("host0")); err != nil {
+		t.Fatalf("renew failed %v", err)
+	}
+	if n := fc.locked(fmt.Sprintf(ServiceLockFormat, "prod", "test.service")); n != 1 {
+		t.Fatalf("service locked %d times, want only by the register", n)
+	}
+	after, _ := fc.GetRaw(key)
+	if after.ModRevision != before.ModRevision {
+		t.Fatalf("renew rewrote the instance, mod revision %d -> %d", before.ModRevision, after.ModRevision)
+	}
+	if n := fc.keepAliveTimes(clientv3.LeaseID(before.Lease)); n != 1 {
+		t.Fatalf("lease kept alive %d times, want 1", n)
+	}
+	if kv, _ := fc.GetRaw(renewKey("prod", "test.service", "host0")); kv != nil {
+		t.Fatal("renew marker written out of self preservation")
+	}
+
+	//the evict rounds need the marker to tell the renewing ones in self preservation.
+	r.setProtected(true)
+	if _, err := r.Renew(nil, testArgRenew("host0")); err != nil {
+		t.Fatalf("renew failed %v", err)
+	}
+	if kv, _ := fc.GetRaw(renewKey("prod", "test.service", "host0")); kv == nil || kv.Lease != before.Lease {
+		t.Fatalf("renew marker got %v, want written on the lease in self preservation", kv)
+	}
+	if kv, _ := fc.GetRaw(key); kv.ModRevision != before.ModRevision {
+		t.Fatal("renew rewrote the instance in self preservation")
+	}
+}
+
+func TestRegisterLease(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	arg := testArgRegister("host0")
+	if err := r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	kv, err := fc.GetRaw("/registry/prod/test.service/host0")
+	if err != nil || kv == nil || kv.Lease == 0 {
+		t.Fatalf("instance key not attached to a lease, got %v %v", kv, err)
+	}
+	if l := fc.leases[clientv3.LeaseID(kv.Lease)]; l == nil || l.ttl != 90 {
+		t.Fatalf("lease %+v, want the lease window 90 as ttl", l)
+	}
+	if sc, err := r.service("prod", "test.service"); err != nil || sc == nil || len(sc.Instances) != 0 {
+		t.Fatalf("service stored without the instances got %v %v", sc, err)
+	}
+
+	//register again keeps the lease alive.
+	if err = r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register again failed %v", err)
+	}
+	kv2, _ := fc.GetRaw("/registry/prod/test.service/host0")
+	if kv2.Lease != kv.Lease || fc.keepAliveTimes(clientv3.LeaseID(kv.Lease)) != 1 {
+		t.Fatalf("register again got lease %d, want %d kept alive", kv2.Lease, kv.Lease)
+	}
+
+	//the lease expired, the instance is gone and renew tells to register again.
+	fc.expire(clientv3.LeaseID(kv.Lease))
+	if _, err = r.Renew(nil, testArgRenew("host0")); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("renew expired got %v, want nothing found", err)
+	}
+	if err = r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register after expired failed %v", err)
+	}
+	kv3, _ := fc.GetRaw("/registry/prod/test.service/host0")
+	if kv3 == nil || kv3.Lease == kv.Lease {
+		t.Fatalf("register after expired got %v, want a new lease", kv3)
+	}
+}
+
+func TestRenewDirtyTimestamp(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	arg := testArgRegister("host0")
+	reg := NewInstance(arg)
+	if err := r.Register(nil, arg, reg); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	//the caller holds a newer one, register again.
+	renew := testArgRenew("host0")
+	renew.DirtyTimestamp = reg.DirtyTimestamp + 1
+	if _, err := r.Renew(nil, renew); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("renew newer dirty timestamp got %v, want nothing found", err)
+	}
+
+	//the caller holds an older one, take ours.
+	renew.DirtyTimestamp = reg.DirtyTimestamp - 1
+	ins, err := r.Renew(nil, renew)
+	if !ecode.EqualError(ecode.Conflict, err) || ins == nil || ins.DirtyTimestamp != reg.DirtyTimestamp {
+		t.Fatalf("renew older dirty timestamp got %v %v, want conflict with ours", ins, err)
+	}
+
+	renew.DirtyTimestamp = reg.DirtyTimestamp
+	if _, err = r.Renew(nil, renew); err != nil {
+		t.Fatalf("renew same dirty timestamp failed %v", err)
+	}
+}
+
+func testArgLogOff(hostname string) *ArgLogOff {
+	return &ArgLogOff{
+		ServiceId: "test.service",
+		Zone:      "sh1",
+		Env:       "prod",
+		Hostname:  hostname,
+	}
+}
+
+func TestLogOff(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	arg := testArgRegister("host0")
+	reg := NewInstance(arg)
+	if err := r.Register(nil, arg, reg); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	kv, _ := fc.GetRaw("/registry/prod/test.service/host0")
+
+	wrongZone := testArgLogOff("host0")
+	wrongZone.Zone = "sh2"
+	if err := r.LogOff(nil, wrongZone); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("logoff in other zone got %v, want nothing found", err)
+	}
+
+	//a logoff older than the instance registered.
+	stale := testArgLogOff("host0")
+	stale.LatestTimestamp = reg.LatestTimestamp - 1
+	if err := r.LogOff(nil, stale); !ecode.EqualError(ecode.Conflict, err) {
+		t.Fatalf("stale logoff got %v, want conflict", err)
+	}
+
+	if err := r.LogOff(nil, testArgLogOff("host0")); err != nil {
+		t.Fatalf("logoff failed %v", err)
+	}
+	if ins, _ := r.instance("/registry/prod/test.service/host0"); ins != nil {
+		t.Fatalf("instance still stored after logoff %v", ins)
+	}
+	if _, ok := fc.leases[clientv3.LeaseID(kv.Lease)]; ok {
+		t.Fatal("lease not revoked after logoff")
+	}
+	if sc, _ := r.service("prod", "test.service"); sc == nil || sc.LatestTimestamp <= reg.LatestTimestamp {
+		t.Fatalf("service latest timestamp not bumped by the logoff, got %v", sc)
+	}
+
+	if err := r.LogOff(nil, testArgLogOff("host0")); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("logoff again got %v, want nothing found", err)
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	register := func(env, zone, hostname, version string, status uint32) *Instance {
+		arg := testArgRegister(hostname)
+		arg.Env, arg.Zone, arg.Version, arg.Status = env, zone, version, status
+		ins := NewInstance(arg)
+		if err := r.Register(nil, arg, ins); err != nil {
+			t.Fatalf("register %s failed %v", hostname, err)
+		}
+		return ins
+	}
+	register("prod", "sh1", "host0", "v1", InstanceOk)
+	register("prod", "sh1", "host1", "v1", InstanceError)
+	register("prod", "sh2", "host2", "v2", InstanceOk)
+	register("dev", "sh1", "host3", "v1", InstanceOk)
+
+	info, err := r.FetchAll(nil, &ArgFetchAll{ServiceId: "test.service", Env: "prod"})
+	if err != nil {
+		t.Fatalf("fetch failed %v", err)
+	}
+	if len(info.Instances) != 2 || len(info.Instances["sh1"]) != 1 || info.Instances["sh1"][0].HostName != "host0" || len(info.Instances["sh2"]) != 1 {
+		t.Fatalf("fetch prod got %v, want host0 in sh1 and host2 in sh2", info.Instances)
+	}
+
+	info, err = r.FetchAll(nil, &ArgFetchAll{ServiceId: "test.service", Env: "prod", Zone: "sh2", Version: "v2"})
+	if err != nil || len(info.Instances) != 1 || info.Instances["sh2"][0].HostName != "host2" {
+		t.Fatalf("fetch zone sh2 version v2 got %v %v", info, err)
+	}
+
+	info, err = r.FetchAll(nil, &ArgFetchAll{ServiceId: "test.service", Env: "prod", Status: 1 << InstanceError})
+	if err != nil || len(info.Instances["sh1"]) != 1 || info.Instances["sh1"][0].HostName != "host1" {
+		t.Fatalf("fetch error status got %v %v", info, err)
+	}
+
+	//not across the envs, the whole registry would be read.
+	if _, err = r.FetchAll(nil, &ArgFetchAll{ServiceId: "test.service"}); !ecode.EqualError(ecode.RequestErr, err) {
+		t.Fatalf("fetch without env got %v, want request error", err)
+	}
+
+	if _, err = r.FetchAll(nil, &ArgFetchAll{ServiceId: "other.service", Env: "prod"}); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("fetch service not registered got %v, want nothing found", err)
+	}
+}
+
+func TestFetchAllNotModified(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	arg := testArgRegister("host0")
+	if err := r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	fetch := &ArgFetchAll{ServiceId: "test.service", Env: "prod"}
+	info, err := r.FetchAll(nil, fetch)
+	if err != nil {
+		t.Fatalf("fetch failed %v", err)
+	}
+
+	fetch.LatestTimestamp = info.LatestTimestamp
+	if _, err = r.FetchAll(nil, fetch); !ecode.EqualError(ecode.NotModified, err) {
+		t.Fatalf("fetch with the latest timestamp got %v, want not modified", err)
+	}
+
+	//changed since then.
+	arg = testArgRegister("host1")
+	if err = r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	info, err = r.FetchAll(nil, fetch)
+	if err != nil || info.LatestTimestamp <= fetch.LatestTimestamp || len(info.Instances["sh1"]) != 2 {
+		t.Fatalf("fetch after register got %v %v", info, err)
+	}
+}
+
+func TestRegisterServiceTimestamp(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+	sc, err := r.service("prod", "test.service")
+	if err != nil || sc == nil {
+		t.Fatalf("get service got %v %v", sc, err)
+	}
+	latest := sc.LatestTimestamp
+
+	//replicated from another zone, registered there before.
+	arg := testArgRegister("host-sh2")
+	arg.Zone = "sh2"
+	arg.LatestTimestamp = latest - int64(time.Hour)
+	arg.DirtyTimestamp = latest - int64(time.Hour)
+	arg.FromZone = true
+	if err = r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	if sc, err = r.service("prod", "test.service"); err != nil || sc == nil || sc.LatestTimestamp <= latest {
+		t.Fatalf("service got %v %v, want the latest timestamp moved forward from %d", sc, err, latest)
+	}
+}
@@ -0,0 +1,397 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"io"
+	"net/http"
+	"net/url"
+	"nmid-registry/pkg/loger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//replicate actions
+const (
+	ReplicateRegister = "register"
+	ReplicateRenew    = "renew"
+	ReplicateLogOff   = "logoff"
+)
+
+const (
+	replicateQueueSize  = 4096
+	replicateMaxRetry   = 5
+	replicateMinBackoff = 100 * time.Millisecond
+	replicateMaxBackoff = 10 * time.Second
+	replicateTimeout    = 2 * time.Second
+	replicateBreakTime  = 30 * time.Second //the replication dropped at once after a task given up, not to pile up on a peer down
+)
+
+//ReplicationStats the replication to a peer zone.
+type ReplicationStats struct {
+	Zone        string   `json:"zone"`
+	Nodes       []string `json:"nodes"`
+	Queued      int      `json:"queued"`
+	Sent        uint64   `json:"sent"`
+	Failed      uint64   `json:"failed"`  //attempts failed and retried
+	Dropped     uint64   `json:"dropped"` //given up after retries or the queue full
+	LagMs       int64    `json:"lag_ms"`  //from the write accepted here to replicated, of the pending or the latest one
+	LastError   string   `json:"last_error"`
+	LastSuccess int64    `json:"last_success"`
+	BrokenUntil int64    `json:"broken_until"` //the tasks dropped at once until then
+}
+
+type replicateTask struct {
+	action      string
+	ins         *Instance
+	inFlowAddr  string
+	outFlowAddr string
+	enqueued    time.Time
+}
+
+//peer the registry of a peer zone, the writes are replicated to it in order by one worker.
+type peer struct {
+	zone  string
+	nodes []string
+	node  int
+
+	queue      chan *replicateTask
+	httpClient *http.Client
+	minBackoff time.Duration
+	breakTime  time.Duration
+
+	mutex       sync.Mutex
+	current     *replicateTask
+	renews      map[string]*replicateTask //instance key -> the renew queued, the later renews merged into it
+	brokenUntil time.Time
+	stats       ReplicationStats
+}
+
+type replicateResponse struct {
+	Code int             `json:"code"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newPeer(zone string, addrs []string) *peer {
+	nodes := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+			addr = "http://" + addr
+		}
+		nodes = append(nodes, strings.TrimSuffix(addr, "/"))
+	}
+
+	return &peer{
+		zone:       zone,
+		nodes:      nodes,
+		queue:      make(chan *replicateTask, replicateQueueSize),
+		httpClient: &http.Client{Timeout: replicateTimeout},
+		minBackoff: replicateMinBackoff,
+		breakTime:  replicateBreakTime,
+		renews:     make(map[string]*replicateTask),
+		stats:      ReplicationStats{Zone: zone, Nodes: addrs},
+	}
+}
+
+//startReplicate start a worker for each peer zone.
+func (r *Registry) startReplicate(peers map[string][]string) {
+	for zone, addrs := range peers {
+		p := newPeer(zone, addrs)
+		r.peers = append(r.peers, p)
+		go r.doReplicate(p)
+	}
+	sort.Slice(r.peers, func(i, j int) bool {
+		return r.peers[i].zone < r.peers[j].zone
+	})
+}
+
+//replicate the write to all the peer zones, the write replicated from other zones should not be replicated again.
+//the renew of the instance with a renew queued already is merged into that one.
+func (r *Registry) replicate(action string, ins *Instance, inFlowAddr, outFlowAddr string) {
+	key := instanceKey(ins.Env, ins.ServiceId, ins.HostName)
+	for _, p := range r.peers {
+		task := &replicateTask{
+			action:      action,
+			ins:         ins,
+			inFlowAddr:  inFlowAddr,
+			outFlowAddr: outFlowAddr,
+			enqueued:    time.Now(),
+		}
+
+		p.mutex.Lock()
+		if action == ReplicateRenew {
+			if queued, ok := p.renews[key]; ok {
+				queued.ins, queued.inFlowAddr, queued.outFlowAddr = ins, inFlowAddr, outFlowAddr
+				p.mutex.Unlock()
+				continue
+			}
+			p.renews[key] = task
+		}
+		select {
+		case p.queue <- task:
+		default:
+			if action == ReplicateRenew {
+				delete(p.renews, key)
+			}
+			p.stats.Dropped++
+			loger.Loger.Warnf("replicate %s service(%s) hostname(%s) to zone(%s) dropped, queue full", action, ins.ServiceId, ins.HostName, p.zone)
+		}
+		p.mutex.Unlock()
+	}
+}
+
+//ReplicationStats the replication to each peer zone.
+func (r *Registry) ReplicationStats() []ReplicationStats {
+	stats := make([]ReplicationStats, 0, len(r.peers))
+	for _, p := range r.peers {
+		p.mutex.Lock()
+		st := p.stats
+		if p.current != nil {
+			if lag := time.Since(p.current.enqueued).Milliseconds(); lag > st.LagMs {
+				st.LagMs = lag
+			}
+		}
+		p.mutex.Unlock()
+		st.Queued = len(p.queue)
+		stats = append(stats, st)
+	}
+
+	return stats
+}
+
+func (r *Registry) doReplicate(p *peer) {
+	for {
+		select {
+		case task := <-p.queue:
+			p.mutex.Lock()
+			p.current = task
+			//the renews from now on queued again, not merged into the one sending.
+			if task.action == ReplicateRenew {
+				delete(p.renews, instanceKey(task.ins.Env, task.ins.ServiceId, task.ins.HostName))
+			}
+			p.mutex.Unlock()
+
+			r.replicateTask(p, task)
+
+			p.mutex.Lock()
+			p.current = nil
+			p.mutex.Unlock()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+//replicateTask send the task to the peer, retry with backoff when the peer unreachable,
+//the tasks are dropped at once for a while after one given up.
+func (r *Registry) replicateTask(p *peer, task *replicateTask) {
+	p.mutex.Lock()
+	broken := time.Now().Before(p.brokenUntil)
+	if broken {
+		p.stats.Dropped++
+	}
+	p.mutex.Unlock()
+	if broken {
+		return
+	}
+
+	backoff := p.minBackoff
+	for i := 0; ; i++ {
+		err := r.send(p, task)
+		if nil == err {
+			p.mutex.Lock()
+			p.stats.Sent++
+			p.stats.LagMs = time.Since(task.enqueued).Milliseconds()
+			p.stats.LastSuccess = time.Now().UnixNano()
+			p.mutex.Unlock()
+			return
+		}
+
+		p.mutex.Lock()
+		p.stats.LastError = err.Error()
+		if i >= replicateMaxRetry {
+			p.stats.Dropped++
+			p.brokenUntil = time.Now().Add(p.breakTime)
+			p.stats.BrokenUntil = p.brokenUntil.UnixNano()
+		} else {
+			p.stats.Failed++
+		}
+		p.mutex.Unlock()
+		if i >= replicateMaxRetry {
+			loger.Loger.Errorf("replicate %s service(%s) hostname(%s) to zone(%s) failed %v, given up and drop the replication for %s",
+				task.action, task.ins.ServiceId, task.ins.HostName, p.zone, err, p.breakTime)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-r.done:
+			return
+		}
+		backoff *= 2
+		if backoff > replicateMaxBackoff {
+			backoff = replicateMaxBackoff
+		}
+	}
+}
+
+func (r *Registry) send(p *peer, task *replicateTask) error {
+	ins := task.ins
+	switch task.action {
+	case ReplicateRegister:
+		return p.register(task)
+	case ReplicateRenew:
+		params := url.Values{}
+		params.Set("service_id", ins.ServiceId)
+		params.Set("inflow_addr", task.inFlowAddr)
+		params.Set("outflow_addr", task.outFlowAddr)
+		params.Set("zone", ins.Zone)
+		params.Set("env", ins.Env)
+		params.Set("hostname", ins.HostName)
+		params.Set("status", strconv.FormatUint(uint64(ins.Status), 10))
+		params.Set("dirty_timestamp", strconv.FormatInt(ins.DirtyTimestamp, 10))
+		params.Set("from_zone", "true")
+
+		resp, err := p.post("/registry/renew", params)
+		if nil != err {
+			return err
+		}
+		switch {
+		case ecode.EqualError(ecode.NothingFound, ecode.Int(resp.Code)):
+			//the peer lost it or holds an older one.
+			return p.register(task)
+		case ecode.EqualError(ecode.Conflict, ecode.Int(resp.Code)):
+			//the peer holds a newer one, take it.
+			return r.registerReplicated(resp.Data, task)
+		case resp.Code != 0:
+			return ecode.Int(resp.Code)
+		}
+		return nil
+	case ReplicateLogOff:
+		params := url.Values{}
+		params.Set("service_id", ins.ServiceId)
+		params.Set("zone", ins.Zone)
+		params.Set("env", ins.Env)
+		params.Set("hostname", ins.HostName)
+		params.Set("latest_timestamp", strconv.FormatInt(ins.LatestTimestamp, 10))
+		params.Set("from_zone", "true")
+
+		resp, err := p.post("/registry/logoff", params)
+		if nil != err {
+			return err
+		}
+		//not found or conflict with a newer one are both fine.
+		if resp.Code != 0 && !ecode.EqualError(ecode.NothingFound, ecode.Int(resp.Code)) && !ecode.EqualError(ecode.Conflict, ecode.Int(resp.Code)) {
+			return ecode.Int(resp.Code)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown replicate action %s", task.action)
+}
+
+//registerReplicated register the newer instance got from the peer.
+func (r *Registry) registerReplicated(data json.RawMessage, task *replicateTask) error {
+	ins := new(Instance)
+	if err := json.Unmarshal(data, ins); err != nil {
+		return err
+	}
+
+	arg := &ArgRegister{
+		ServiceId:       ins.ServiceId,
+		InFlowAddr:      task.inFlowAddr,
+		OutFlowAddr:     task.outFlowAddr,
+		Region:          ins.Region,
+		Zone:            ins.Zone,
+		Env:             ins.Env,
+		Hostname:        ins.HostName,
+		Status:          ins.Status,
+		Addrs:           ins.Addrs,
+		Version:         ins.Version,
+		Weight:          ins.Weight,
+		LatestTimestamp: ins.LatestTimestamp,
+		DirtyTimestamp:  ins.DirtyTimestamp,
+		FromZone:        true,
+	}
+	if len(ins.Metadata) > 0 {
+		metadata, err := json.Marshal(ins.Metadata)
+		if nil != err {
+			return err
+		}
+		arg.Metadata = string(metadata)
+	}
+
+	return r.Register(context.Background(), arg, NewInstance(arg))
+}
+
+func (p *peer) register(task *replicateTask) error {
+	ins := task.ins
+	params := url.Values{}
+	params.Set("service_id", ins.ServiceId)
+	params.Set("inflow_addr", task.inFlowAddr)
+	params.Set("outflow_addr", task.outFlowAddr)
+	params.Set("region", ins.Region)
+	params.Set("zone", ins.Zone)
+	params.Set("env", ins.Env)
+	params.Set("hostname", ins.HostName)
+	params.Set("status", strconv.FormatUint(uint64(ins.Status), 10))
+	params.Set("version", ins.Version)
+	params.Set("weight", strconv.FormatInt(ins.Weight, 10))
+	for _, addr := range ins.Addrs {
+		params.Add("addrs", addr)
+	}
+	if len(ins.Metadata) > 0 {
+		metadata, err := json.Marshal(ins.Metadata)
+		if nil != err {
+			return err
+		}
+		params.Set("metadata", string(metadata))
+	}
+	params.Set("latest_timestamp", strconv.FormatInt(ins.LatestTimestamp, 10))
+	params.Set("dirty_timestamp", strconv.FormatInt(ins.DirtyTimestamp, 10))
+	params.Set("from_zone", "true")
+
+	resp, err := p.post("/registry/register", params)
+	if nil != err {
+		return err
+	}
+	if resp.Code != 0 {
+		return ecode.Int(resp.Code)
+	}
+
+	return nil
+}
+
+//post to the nodes of the peer in turn until one of them reachable.
+func (p *peer) post(path string, params url.Values) (*replicateResponse, error) {
+	var lastErr error
+	for i := 0; i < len(p.nodes); i++ {
+		node := p.nodes[p.node%len(p.nodes)]
+
+		resp, err := p.httpClient.PostForm(node+path, params)
+		if nil == err {
+			var body []byte
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if nil == err && resp.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("http status %d", resp.StatusCode)
+			}
+			if nil == err {
+				ret := new(replicateResponse)
+				if err = json.Unmarshal(body, ret); nil == err {
+					return ret, nil
+				}
+			}
+		}
+
+		lastErr = fmt.Errorf("node(%s) %v", node, err)
+		p.node++
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,293 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nmid-registry/pkg/option"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakePeer the registry api of a peer zone, replying the codes queued for each path, 0 when none left.
+type fakePeer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests []fakePeerRequest
+	codes    map[string][]int
+	fails    int //requests answered with http 500
+}
+
+type fakePeerRequest struct {
+	path string
+	form url.Values
+}
+
+func newFakePeer(t *testing.T) *fakePeer {
+	fp := &fakePeer{codes: make(map[string][]int)}
+	fp.Server = httptest.NewServer(http.HandlerFunc(fp.serve))
+	t.Cleanup(fp.Close)
+
+	return fp
+}
+
+func (fp *fakePeer) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	if fp.fails > 0 {
+		fp.fails--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fp.requests = append(fp.requests, fakePeerRequest{path: r.URL.Path, form: r.PostForm})
+	code := 0
+	if codes := fp.codes[r.URL.Path]; len(codes) > 0 {
+		code, fp.codes[r.URL.Path] = codes[0], codes[1:]
+	}
+	fmt.Fprintf(w, `{"code":%d}`, code)
+}
+
+func (fp *fakePeer) reply(path string, codes ...int) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	fp.codes[path] = append(fp.codes[path], codes...)
+}
+
+func (fp *fakePeer) fail(n int) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	fp.fails = n
+}
+
+//waitRequests wait for n requests replicated, the paths of them.
+func (fp *fakePeer) waitRequests(t *testing.T, n int) []fakePeerRequest {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fp.mutex.Lock()
+		requests := append([]fakePeerRequest(nil), fp.requests...)
+		fp.mutex.Unlock()
+		if len(requests) >= n {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replicated %d requests, want %d", len(requests), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//waitSent wait for n tasks sent to the peer, the replication stats then.
+func waitSent(t *testing.T, r *Registry, n uint64) ReplicationStats {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := r.ReplicationStats()
+		if len(stats) != 1 {
+			t.Fatalf("replication stats %+v, want one peer zone", stats)
+		}
+		if stats[0].Sent >= n {
+			return stats[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replication stats %+v, want %d sent", stats[0], n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+//newReplicateRegistry the registry replicating to the peer as zone sh2.
+func newReplicateRegistry(t *testing.T, fp *fakePeer) *Registry {
+	t.Helper()
+
+	r := NewRegistry(&option.Options{
+		RegistryLeaseWindow:      90 * time.Second,
+		RegistryEvictInterval:    time.Hour,
+		RegistryProtectThreshold: 0.15,
+		RegistryPeers:            []string{"sh2=" + fp.URL},
+	}, newFakeCluster())
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func TestReplicate(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+
+	arg := testArgRegister("host0")
+	arg.Metadata = `{"weight":"10"}`
+	ins := NewInstance(arg)
+	if err := r.Register(nil, arg, ins); nil != err {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := r.Renew(nil, testArgRenew("host0")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	if err := r.LogOff(nil, &ArgLogOff{ServiceId: "test.service", Zone: "sh1", Env: "prod", Hostname: "host0"}); nil != err {
+		t.Fatalf("logoff: %v", err)
+	}
+
+	requests := fp.waitRequests(t, 3)
+	paths := []string{"/registry/register", "/registry/renew", "/registry/logoff"}
+	for i, req := range requests {
+		if req.path != paths[i] {
+			t.Fatalf("replicated %s at %d, want %s", req.path, i, paths[i])
+		}
+		if req.form.Get("from_zone") != "true" || req.form.Get("hostname") != "host0" {
+			t.Fatalf("replicated %s with %v", req.path, req.form)
+		}
+	}
+	reg := requests[0].form
+	if reg.Get("dirty_timestamp") != fmt.Sprint(ins.DirtyTimestamp) || reg.Get("metadata") != arg.Metadata || reg.Get("addrs") != arg.Addrs[0] {
+		t.Fatalf("replicated register with %v", reg)
+	}
+
+	//the ones replicated from other zones not replicated again.
+	arg = testArgRegister("host1")
+	arg.FromZone = true
+	if err := r.Register(nil, arg, NewInstance(arg)); nil != err {
+		t.Fatalf("register: %v", err)
+	}
+	renew := testArgRenew("host1")
+	renew.FromZone = true
+	if _, err := r.Renew(nil, renew); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if requests = fp.waitRequests(t, 3); len(requests) != 3 {
+		t.Fatalf("replicated %d requests from other zones", len(requests)-3)
+	}
+
+	if stats := waitSent(t, r, 3); stats.Zone != "sh2" || stats.Sent != 3 || stats.Queued != 0 {
+		t.Fatalf("replication stats %+v", stats)
+	}
+}
+
+func TestReplicateRenewNotFound(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+	registerHosts(t, r, 1)
+	fp.waitRequests(t, 1)
+
+	//the peer lost it, registered again there.
+	fp.reply("/registry/renew", ecode.NothingFound.Code())
+	if _, err := r.Renew(nil, testArgRenew("host-0")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	requests := fp.waitRequests(t, 3)
+	if requests[1].path != "/registry/renew" || requests[2].path != "/registry/register" {
+		t.Fatalf("replicated %v, want renew then register", requests)
+	}
+}
+
+func TestReplicateRetry(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+
+	fp.fail(2)
+	registerHosts(t, r, 1)
+	fp.waitRequests(t, 1)
+
+	if stats := waitSent(t, r, 1); stats.Sent != 1 || stats.Failed != 2 || stats.Dropped != 0 || stats.LastError == "" {
+		t.Fatalf("replication stats %+v, want sent after 2 failed", stats)
+	}
+}
+
+func TestReplicateRenewMerged(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+
+	//the renews queued while the register retried are merged into one.
+	fp.fail(1)
+	registerHosts(t, r, 1)
+	for i := 0; i < 3; i++ {
+		if _, err := r.Renew(nil, testArgRenew("host-0")); nil != err {
+			t.Fatalf("renew: %v", err)
+		}
+	}
+
+	stats := waitSent(t, r, 2)
+	time.Sleep(50 * time.Millisecond)
+	requests := fp.waitRequests(t, 2)
+	if len(requests) != 2 || requests[0].path != "/registry/register" || requests[1].path != "/registry/renew" {
+		t.Fatalf("replicated %v, want register then a single renew", requests)
+	}
+	if stats.Dropped != 0 {
+		t.Fatalf("replication stats %+v, want the renews merged not dropped", stats)
+	}
+
+	//queued again once the one merged into sent.
+	if _, err := r.Renew(nil, testArgRenew("host-0")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	if requests = fp.waitRequests(t, 3); requests[2].path != "/registry/renew" {
+		t.Fatalf("replicated %v, want the renew after sent", requests)
+	}
+}
+
+func TestReplicateErrorCode(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+	registerHosts(t, r, 1)
+	waitSent(t, r, 1)
+
+	//the codes other than not found and conflict are failures, retried.
+	fp.reply("/registry/renew", ecode.ServerErr.Code())
+	fp.reply("/registry/logoff", ecode.ServerErr.Code(), ecode.NothingFound.Code())
+	if _, err := r.Renew(nil, testArgRenew("host-0")); nil != err {
+		t.Fatalf("renew: %v", err)
+	}
+	if err := r.LogOff(nil, testArgLogOff("host-0")); nil != err {
+		t.Fatalf("logoff: %v", err)
+	}
+
+	if stats := waitSent(t, r, 3); stats.Failed != 2 || stats.Dropped != 0 {
+		t.Fatalf("replication stats %+v, want the 2 error codes retried", stats)
+	}
+	requests := fp.waitRequests(t, 5)
+	if requests[1].path != "/registry/renew" || requests[2].path != "/registry/renew" || requests[4].path != "/registry/logoff" {
+		t.Fatalf("replicated %v, want the renew and the logoff retried", requests)
+	}
+}
+
+func TestReplicateBroken(t *testing.T) {
+	fp := newFakePeer(t)
+	r := newReplicateRegistry(t, fp)
+	r.peers[0].minBackoff = time.Millisecond
+
+	//given up after the retries, the next ones dropped at once.
+	fp.fail(replicateMaxRetry + 10)
+	registerHosts(t, r, 2)
+
+	deadline := time.Now().Add(5 * time.Second)
+	stats := r.ReplicationStats()[0]
+	for stats.Dropped != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("replication stats %+v, want both dropped", stats)
+		}
+		time.Sleep(5 * time.Millisecond)
+		stats = r.ReplicationStats()[0]
+	}
+	if stats.Failed != replicateMaxRetry || stats.BrokenUntil <= time.Now().UnixNano() {
+		t.Fatalf("replication stats %+v, want broken after %d failed", stats, replicateMaxRetry)
+	}
+
+	fp.mutex.Lock()
+	fails := fp.fails
+	fp.mutex.Unlock()
+	if fails != 10-1 {
+		t.Fatalf("%d requests sent to the peer broken, want none after given up", 10-fails)
+	}
+}
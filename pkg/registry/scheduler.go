@@ -1 +1,122 @@
 package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"nmid-registry/pkg/loger"
+	"time"
+)
+
+const (
+	SchedulerFormat = "/schedulers/%s/%s" // +env +serviceid
+)
+
+//Scheduler the traffic weights from the callers of each zone to the zones of the service,
+//e.g. 80% of sh1 callers to sh1 and 20% to sh2 during migrations.
+type Scheduler struct {
+	ServiceId string           `json:"service_id"`
+	Env       string           `json:"env"`
+	Zones     []*ZoneScheduler `json:"zones"`
+}
+
+type ZoneScheduler struct {
+	Src string           `json:"src"` // zone of the callers
+	Dst map[string]int64 `json:"dst"` // zone of the service -> weight
+}
+
+func schedulerKey(env, serviceId string) string {
+	return fmt.Sprintf(SchedulerFormat, env, serviceId)
+}
+
+//SetScheduler replace the scheduler of the service, remove it if no zones,
+//the service is touched so that the fetching and watching callers get it.
+func (r *Registry) SetScheduler(ctx context.Context, arg *ArgSetScheduler) error {
+	var zones []*ZoneScheduler
+	if arg.Content != "" {
+		if err := json.Unmarshal([]byte(arg.Content), &zones); err != nil {
+			loger.Loger.Errorf("set scheduler service(%s) env(%s) content invalid %v", arg.ServiceId, arg.Env, err)
+			return ecode.RequestErr
+		}
+	}
+	if !validZones(zones) {
+		return ecode.RequestErr
+	}
+
+	unlock, err := r.lockService(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	if err = r.storeScheduler(arg.Env, arg.ServiceId, zones); err != nil {
+		return err
+	}
+	loger.Loger.Infof("set scheduler service(%s) env(%s) content(%s)", arg.ServiceId, arg.Env, arg.Content)
+
+	return nil
+}
+
+func validZones(zones []*ZoneScheduler) bool {
+	for _, zone := range zones {
+		if zone == nil || zone.Src == "" || len(zone.Dst) == 0 {
+			return false
+		}
+		for _, weight := range zone.Dst {
+			if weight < 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+//storeScheduler put the scheduler and touch the service, the caller holds the service lock.
+func (r *Registry) storeScheduler(env, serviceId string, zones []*ZoneScheduler) error {
+	key := schedulerKey(env, serviceId)
+	if len(zones) == 0 {
+		if err := r.cluster.Delete(key); err != nil {
+			return err
+		}
+	} else {
+		val, err := json.Marshal(&Scheduler{ServiceId: serviceId, Env: env, Zones: zones})
+		if nil != err {
+			return err
+		}
+		if err := r.cluster.Put(key, string(val)); err != nil {
+			return err
+		}
+	}
+
+	return r.touchService(env, serviceId, time.Now().UnixNano())
+}
+
+//GetScheduler get the scheduler of the service, nothing found if not set.
+func (r *Registry) GetScheduler(ctx context.Context, arg *ArgGetScheduler) (*Scheduler, error) {
+	sch, err := r.scheduler(arg.Env, arg.ServiceId)
+	if nil != err {
+		return nil, err
+	}
+	if sch == nil {
+		return nil, ecode.NothingFound
+	}
+
+	return sch, nil
+}
+
+//scheduler get the scheduler from the cluster, nil if not exist.
+func (r *Registry) scheduler(env, serviceId string) (*Scheduler, error) {
+	val, err := r.cluster.Get(schedulerKey(env, serviceId))
+	if nil != err || val == "" {
+		return nil, err
+	}
+
+	sch := new(Scheduler)
+	if err := json.Unmarshal([]byte(val), sch); err != nil {
+		return nil, err
+	}
+
+	return sch, nil
+}
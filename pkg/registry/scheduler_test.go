@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"testing"
+)
+
+func TestSetScheduler(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+	fetch := &ArgFetchAll{ServiceId: "test.service", Env: "prod"}
+	before, err := r.FetchAll(nil, fetch)
+	if nil != err || before.Scheduler != nil {
+		t.Fatalf("fetch all got %v %v, want not scheduled", before, err)
+	}
+
+	for _, content := range []string{
+		`{"src":"sh1"}`,
+		`[{"dst":{"sh1":1}}]`,
+		`[{"src":"sh1"}]`,
+		`[{"src":"sh1","dst":{"sh1":-1}}]`,
+	} {
+		arg := &ArgSetScheduler{ServiceId: "test.service", Env: "prod", Content: content}
+		if err = r.SetScheduler(nil, arg); !ecode.EqualError(ecode.RequestErr, err) {
+			t.Fatalf("set scheduler %s got %v, want request error", content, err)
+		}
+	}
+
+	arg := &ArgSetScheduler{ServiceId: "test.service", Env: "prod", Content: `[{"src":"sh1","dst":{"sh1":80,"sh2":20}}]`}
+	if err = r.SetScheduler(nil, arg); nil != err {
+		t.Fatalf("set scheduler: %v", err)
+	}
+	sch, err := r.GetScheduler(nil, &ArgGetScheduler{ServiceId: "test.service", Env: "prod"})
+	if nil != err || len(sch.Zones) != 1 || sch.Zones[0].Src != "sh1" || sch.Zones[0].Dst["sh2"] != 20 {
+		t.Fatalf("get scheduler got %+v %v", sch, err)
+	}
+
+	//returned with the instances, and the fetching callers see the change.
+	info, err := r.FetchAll(nil, fetch)
+	if nil != err || info.Scheduler == nil || info.Scheduler.Zones[0].Dst["sh1"] != 80 {
+		t.Fatalf("fetch all got %+v %v, want scheduled", info, err)
+	}
+	if info.LatestTimestamp <= before.LatestTimestamp {
+		t.Fatal("service not touched by the scheduler set")
+	}
+
+	//removed when no zones.
+	if err = r.SetScheduler(nil, &ArgSetScheduler{ServiceId: "test.service", Env: "prod"}); nil != err {
+		t.Fatalf("remove scheduler: %v", err)
+	}
+	if _, err = r.GetScheduler(nil, &ArgGetScheduler{ServiceId: "test.service", Env: "prod"}); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("get scheduler removed got %v, want nothing found", err)
+	}
+	if info, err = r.FetchAll(nil, fetch); nil != err || info.Scheduler != nil {
+		t.Fatalf("fetch all got %+v %v, want not scheduled", info, err)
+	}
+}
+
+func TestValidZones(t *testing.T) {
+	cases := []struct {
+		name  string
+		zones []*ZoneScheduler
+		want  bool
+	}{
+		{"none", nil, true},
+		{"valid", []*ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": 80, "sh2": 20}}}, true},
+		{"zero weight", []*ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": 0, "sh2": 100}}}, true},
+		{"nil zone", []*ZoneScheduler{nil}, false},
+		{"no src", []*ZoneScheduler{{Dst: map[string]int64{"sh1": 1}}}, false},
+		{"no dst", []*ZoneScheduler{{Src: "sh1"}}, false},
+		{"negative weight", []*ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": -1}}}, false},
+		{"one invalid", []*ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": 1}}, {Src: "sh2"}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := validZones(c.zones); got != c.want {
+				t.Fatalf("valid zones got %v, want %v", got, c.want)
+			}
+		})
+	}
+}
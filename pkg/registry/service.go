@@ -2,28 +2,55 @@ package registry
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
-//instance status
+//instance status, 0 the status of the instance registered without one.
 const (
-	InstanceOk = iota
-	InstanceError
+	InstanceOk          = iota //up, the only status serving
+	InstanceError              //down
+	InstanceDraining           //finishing the requests in flight, no new traffic
+	InstanceMaintenance        //taken out of rotation by operators
 )
 
-var (
-	now = time.Now().UnixNano()
+//InstanceAll the status bits of all the instances, fetch filters by 1<<status of each status wanted.
+const InstanceAll = 1<<InstanceOk | 1<<InstanceError | 1<<InstanceDraining | 1<<InstanceMaintenance
+
+//DefaultWeight the weight of the instance registered without one.
+const DefaultWeight = 10
+
+//etcd keys
+const (
+	ServicePrefix  = "/services/"
+	ServiceFormat  = "/services/%s/%s" // +env +serviceid
+	InstancePrefix = "/registry/"
+	InstanceFormat = "/registry/%s/%s/%s"  // +env +serviceid +hostname
+	OverrideFormat = "/overrides/%s/%s/%s" // +env +serviceid +hostname
+	RenewPrefix    = "/renews/"
+	RenewFormat    = "/renews/%s/%s/%s"  // +env +serviceid +hostname
+	LogOffPrefix   = "/logoffs/"
+	LogOffFormat   = "/logoffs/%s/%s/%s" // +env +serviceid +hostname
+	ProtectedKey   = "/protected"
 )
 
 type Service struct {
 	ServiceId   string
+	Env         string
 	InFlowAddr  string
 	OutFlowAddr string
-	Instances   []*Instance
+	Instances   []*Instance `json:",omitempty"`
 
 	LatestTimestamp int64
 }
 
+//InstanceInfo the instances of a service grouped by zone.
+type InstanceInfo struct {
+	Instances       map[string][]*Instance `json:"instances"`
+	Scheduler       *Scheduler             `json:"scheduler,omitempty"`
+	LatestTimestamp int64                  `json:"latest_timestamp"`
+}
+
 type Instance struct {
 	ServiceId string
 	Region    string
@@ -33,21 +60,27 @@ type Instance struct {
 	Addrs     []string
 	Version   string
 	Metadata  map[string]string
+	Weight    int64
 
 	Status uint32
 
-	//timestamp
+	//timestamp, RenewTimestamp is not stored, the one stored is when registered.
 	RegTimestamp   int64
 	UpTimestamp    int64
 	RenewTimestamp int64
 	DirtyTimestamp int64
 
 	LatestTimestamp int64
+
+	//etcd lease the instance key attached to
+	lease int64
 }
 
 func NewService(arg *ArgRegister) *Service {
+	now := time.Now().UnixNano()
 	return &Service{
 		ServiceId:       arg.ServiceId,
+		Env:             arg.Env,
 		InFlowAddr:      arg.InFlowAddr,
 		OutFlowAddr:     arg.OutFlowAddr,
 		Instances:       make([]*Instance, 0),
@@ -56,6 +89,7 @@ func NewService(arg *ArgRegister) *Service {
 }
 
 func NewInstance(arg *ArgRegister) *Instance {
+	now := time.Now().UnixNano()
 	ins := &Instance{
 		ServiceId:       arg.ServiceId,
 		Region:          arg.Region,
@@ -64,6 +98,7 @@ func NewInstance(arg *ArgRegister) *Instance {
 		HostName:        arg.Hostname,
 		Addrs:           arg.Addrs,
 		Version:         arg.Version,
+		Weight:          arg.Weight,
 		Status:          arg.Status,
 		RegTimestamp:    now,
 		UpTimestamp:     now,
@@ -72,6 +107,18 @@ func NewInstance(arg *ArgRegister) *Instance {
 		LatestTimestamp: now,
 	}
 
+	if ins.Weight <= 0 {
+		ins.Weight = DefaultWeight
+	}
+
+	//the instance replicated from other zones keeps the timestamps of the origin.
+	if arg.LatestTimestamp > 0 {
+		ins.LatestTimestamp = arg.LatestTimestamp
+	}
+	if arg.DirtyTimestamp > 0 {
+		ins.DirtyTimestamp = arg.DirtyTimestamp
+	}
+
 	metaData := make(map[string]string)
 	if err := json.Unmarshal([]byte(arg.Metadata), &metaData); err == nil {
 		ins.Metadata = metaData
@@ -79,3 +126,53 @@ func NewInstance(arg *ArgRegister) *Instance {
 
 	return ins
 }
+
+//match report whether the instance passes the filters of the fetch.
+func (ins *Instance) match(arg *ArgFetchAll) bool {
+	status := arg.Status
+	if status == 0 {
+		status = 1 << InstanceOk
+	}
+
+	switch {
+	case ins.ServiceId != arg.ServiceId:
+		return false
+	case arg.Region != "" && ins.Region != arg.Region:
+		return false
+	case arg.Zone != "" && ins.Zone != arg.Zone:
+		return false
+	case arg.Env != "" && ins.Env != arg.Env:
+		return false
+	case arg.Version != "" && ins.Version != arg.Version:
+		return false
+	case 1<<ins.Status&status == 0:
+		return false
+	}
+
+	return true
+}
+
+func serviceKey(env, serviceId string) string {
+	return fmt.Sprintf(ServiceFormat, env, serviceId)
+}
+
+func instanceKey(env, serviceId, hostname string) string {
+	return fmt.Sprintf(InstanceFormat, env, serviceId, hostname)
+}
+
+func overrideKey(env, serviceId, hostname string) string {
+	return fmt.Sprintf(OverrideFormat, env, serviceId, hostname)
+}
+
+func renewKey(env, serviceId, hostname string) string {
+	return fmt.Sprintf(RenewFormat, env, serviceId, hostname)
+}
+
+func logOffKey(env, serviceId, hostname string) string {
+	return fmt.Sprintf(LogOffFormat, env, serviceId, hostname)
+}
+
+//instancePrefix is the prefix of all the instances of the service.
+func instancePrefix(env, serviceId string) string {
+	return fmt.Sprintf(InstanceFormat, env, serviceId, "")
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestInstanceMatch(t *testing.T) {
+	ins := &Instance{
+		ServiceId: "test.service",
+		Region:    "sh",
+		Zone:      "sh1",
+		Env:       "prod",
+		Version:   "v1",
+		Status:    InstanceOk,
+	}
+
+	cases := []struct {
+		name string
+		arg  ArgFetchAll
+		want bool
+	}{
+		{"service only", ArgFetchAll{ServiceId: "test.service"}, true},
+		{"all the filters", ArgFetchAll{ServiceId: "test.service", Region: "sh", Zone: "sh1", Env: "prod", Version: "v1", Status: 1 << InstanceOk}, true},
+		{"other service", ArgFetchAll{ServiceId: "other.service"}, false},
+		{"other region", ArgFetchAll{ServiceId: "test.service", Region: "bj"}, false},
+		{"other zone", ArgFetchAll{ServiceId: "test.service", Zone: "sh2"}, false},
+		{"other env", ArgFetchAll{ServiceId: "test.service", Env: "dev"}, false},
+		{"other version", ArgFetchAll{ServiceId: "test.service", Version: "v2"}, false},
+		{"other status", ArgFetchAll{ServiceId: "test.service", Status: 1 << InstanceError}, false},
+		{"status in the bits", ArgFetchAll{ServiceId: "test.service", Status: 1<<InstanceOk | 1<<InstanceDraining}, true},
+		{"all statuses", ArgFetchAll{ServiceId: "test.service", Status: InstanceAll}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ins.match(&c.arg); got != c.want {
+				t.Fatalf("match got %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	//the status not ok only fetched when asked.
+	ins.Status = InstanceMaintenance
+	if ins.match(&ArgFetchAll{ServiceId: "test.service"}) {
+		t.Fatal("maintenance instance matched the default status")
+	}
+	if !ins.match(&ArgFetchAll{ServiceId: "test.service", Status: InstanceAll}) {
+		t.Fatal("maintenance instance not matched all the statuses")
+	}
+}
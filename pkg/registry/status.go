@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"context"
+	"nmid-registry/pkg/loger"
+)
+
+//ValidStatus report whether the status is exactly one of the instance statuses.
+func ValidStatus(status uint32) bool {
+	switch status {
+	case InstanceOk, InstanceError, InstanceDraining, InstanceMaintenance:
+		return true
+	}
+
+	return false
+}
+
+//SetStatus override the status of the instance, set InstanceOk to remove the override.
+func (r *Registry) SetStatus(ctx context.Context, arg *ArgSetStatus) error {
+	unlock, err := r.lockService(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	err = r.overrideInstance(arg.Env, arg.ServiceId, arg.Hostname, func(ins *Instance, o *override) {
+		ins.Status = arg.Status
+		o.Status = &arg.Status
+		if arg.Status == InstanceOk {
+			o.Status = nil
+		}
+	})
+	if nil != err {
+		return err
+	}
+	loger.Loger.Infof("set status service(%s) env(%s) hostname(%s) status(%d)", arg.ServiceId, arg.Env, arg.Hostname, arg.Status)
+
+	return nil
+}
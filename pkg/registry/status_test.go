@@ -0,0 +1,131 @@
+package registry
+
+import (
+	"github.com/go-kratos/kratos/pkg/ecode"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"testing"
+)
+
+func testArgSetStatus(hostname string, status uint32) *ArgSetStatus {
+	return &ArgSetStatus{
+		ServiceId: "test.service",
+		Env:       "prod",
+		Hostname:  hostname,
+		Status:    status,
+	}
+}
+
+//fetchHosts the hostnames of the instances fetched with the status bits.
+func fetchHosts(t *testing.T, r *Registry, status uint32) map[string]uint32 {
+	t.Helper()
+
+	info, err := r.FetchAll(nil, &ArgFetchAll{ServiceId: "test.service", Env: "prod", Status: status})
+	if nil != err {
+		t.Fatalf("fetch all: %v", err)
+	}
+	hosts := make(map[string]uint32)
+	for _, inss := range info.Instances {
+		for _, ins := range inss {
+			hosts[ins.HostName] = ins.Status
+		}
+	}
+
+	return hosts
+}
+
+//overrideOf the override of the instance of test.service in prod.
+func overrideOf(t *testing.T, r *Registry, hostname string) *override {
+	t.Helper()
+
+	o, err := r.override("prod", "test.service", hostname)
+	if nil != err {
+		t.Fatalf("get override of %s: %v", hostname, err)
+	}
+
+	return o
+}
+
+func TestValidStatus(t *testing.T) {
+	for _, status := range []uint32{InstanceOk, InstanceError, InstanceDraining, InstanceMaintenance} {
+		if !ValidStatus(status) {
+			t.Fatalf("status %d invalid", status)
+		}
+	}
+	for _, status := range []uint32{InstanceMaintenance + 1, InstanceAll} {
+		if ValidStatus(status) {
+			t.Fatalf("status %d valid", status)
+		}
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 2)
+
+	if err := r.SetStatus(nil, testArgSetStatus("host-0", InstanceMaintenance)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if hosts := fetchHosts(t, r, 0); len(hosts) != 1 || hosts["host-1"] != InstanceOk {
+		t.Fatalf("fetched %v, want the serving one only", hosts)
+	}
+	if hosts := fetchHosts(t, r, InstanceAll); len(hosts) != 2 || hosts["host-0"] != InstanceMaintenance {
+		t.Fatalf("fetched %v, want all with the status overridden", hosts)
+	}
+	if hosts := fetchHosts(t, r, 1<<InstanceMaintenance); len(hosts) != 1 || hosts["host-0"] != InstanceMaintenance {
+		t.Fatalf("fetched %v, want the maintenance one only", hosts)
+	}
+
+	//survive the renewals and the registering again of the instance itself.
+	ins, err := r.Renew(nil, testArgRenew("host-0"))
+	if nil != err || ins.Status != InstanceMaintenance {
+		t.Fatalf("renew got %v %v, want the status overridden", ins, err)
+	}
+	arg := testArgRegister("host-0")
+	if err = r.Register(nil, arg, NewInstance(arg)); nil != err {
+		t.Fatalf("register again: %v", err)
+	}
+	if ins = storedInstance(t, r, "host-0"); ins.Status != InstanceMaintenance {
+		t.Fatalf("registered again with status %d, want the status overridden", ins.Status)
+	}
+
+	//back to up removes the override.
+	if err = r.SetStatus(nil, testArgSetStatus("host-0", InstanceOk)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if status := overrideOf(t, r, "host-0").Status; status != nil {
+		t.Fatalf("override status %d, want removed", *status)
+	}
+	if hosts := fetchHosts(t, r, 0); len(hosts) != 2 {
+		t.Fatalf("fetched %v, want both serving", hosts)
+	}
+
+	if err = r.SetStatus(nil, testArgSetStatus("host-2", InstanceDraining)); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("set status of the one not registered got %v, want nothing found", err)
+	}
+}
+
+func TestSetStatusExpired(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	registerHosts(t, r, 1)
+
+	if err := r.SetStatus(nil, testArgSetStatus("host-0", InstanceDraining)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if status := overrideOf(t, r, "host-0").Status; status == nil || *status != InstanceDraining {
+		t.Fatalf("override status %v, want draining", status)
+	}
+
+	//gone with the lease of the instance.
+	fc.expire(clientv3.LeaseID(storedInstance(t, r, "host-0").lease))
+	if status := overrideOf(t, r, "host-0").Status; status != nil {
+		t.Fatalf("override status %d, want gone with the instance", *status)
+	}
+	arg := testArgRegister("host-0")
+	if err := r.Register(nil, arg, NewInstance(arg)); nil != err {
+		t.Fatalf("register again: %v", err)
+	}
+	if ins := storedInstance(t, r, "host-0"); ins.Status != InstanceOk {
+		t.Fatalf("registered again with status %d, want ok", ins.Status)
+	}
+}
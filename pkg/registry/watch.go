@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"nmid-registry/pkg/cluster"
+	"time"
+)
+
+const (
+	PollWaitTime = 20 * time.Second
+)
+
+//WatchInfo the instances of the watched services, and the revision to watch from next time.
+type WatchInfo struct {
+	Services map[string]*InstanceInfo `json:"services"` // serviceid -> instances
+	Revision int64                    `json:"revision"`
+}
+
+//DoWatch long poll the services, block until any of them changed since the revision or timeout.
+func (r *Registry) DoWatch(ctx context.Context, arg *ArgDoWatch) (wi *WatchInfo, err error) {
+	//the revision is taken by the first service, the callers not bound by the http api may send none.
+	if len(arg.ServiceIds) == 0 {
+		return nil, ecode.RequestErr
+	}
+	if arg.Revision == 0 {
+		return r.watchInfo(arg)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	metricWatchers.Inc()
+	defer metricWatchers.Dec()
+
+	changed := make(chan struct{}, 1)
+	notify := func(events <-chan cluster.WatchEvent) {
+		for range events {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+		//watch canceled, e.g. the revision compacted, let caller get the current ones.
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, serviceId := range arg.ServiceIds {
+		//instances changed always come along with the service touched, except the lease expired.
+		insEvents, err := r.cluster.DoWatch(ctx, instancePrefix(arg.Env, serviceId),
+			cluster.WatchPrefix(), cluster.WatchNoPut(), cluster.WatchFromRevision(arg.Revision+1))
+		if nil != err {
+			return nil, err
+		}
+		go notify(insEvents)
+
+		scEvents, err := r.cluster.DoWatch(ctx, serviceKey(arg.Env, serviceId), cluster.WatchFromRevision(arg.Revision+1))
+		if nil != err {
+			return nil, err
+		}
+		go notify(scEvents)
+	}
+
+	select {
+	case <-changed:
+		return r.watchInfo(arg)
+	case <-time.After(PollWaitTime):
+		return nil, ecode.NotModified
+	case <-ctx.Done():
+		return nil, ecode.Deadline
+	case <-r.watchDone:
+		return nil, ecode.ServiceUnavailable
+	}
+}
+
+//watchInfo get the current instances of the services, the revision is taken before reading them,
+//so that nothing changed during the reading would be missed next time.
+func (r *Registry) watchInfo(arg *ArgDoWatch) (*WatchInfo, error) {
+	_, rev, err := r.instances(instancePrefix(arg.Env, arg.ServiceIds[0]))
+	if nil != err {
+		return nil, err
+	}
+
+	wi := &WatchInfo{
+		Services: make(map[string]*InstanceInfo, len(arg.ServiceIds)),
+		Revision: rev,
+	}
+
+	for _, serviceId := range arg.ServiceIds {
+		info, _, err := r.fetch(&ArgFetchAll{ServiceId: serviceId, Env: arg.Env})
+		if ecode.EqualError(ecode.NothingFound, err) {
+			continue
+		}
+		if nil != err {
+			return nil, err
+		}
+
+		wi.Services[serviceId] = info
+	}
+
+	return wi, nil
+}
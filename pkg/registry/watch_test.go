@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"context"
+	"github.com/go-kratos/kratos/pkg/ecode"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"testing"
+	"time"
+)
+
+type watchResult struct {
+	wi  *WatchInfo
+	err error
+}
+
+//waitWatching wait until n watches on the cluster.
+func waitWatching(t *testing.T, fc *fakeCluster, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fc.watching() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d watches on the cluster, want %d", fc.watching(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//goWatch long poll in background, return once the watches are set up.
+func goWatch(t *testing.T, r *Registry, fc *fakeCluster, c context.Context, arg *ArgDoWatch) <-chan watchResult {
+	t.Helper()
+
+	waitWatching(t, fc, 0)
+	ret := make(chan watchResult, 1)
+	go func() {
+		wi, err := r.DoWatch(c, arg)
+		ret <- watchResult{wi: wi, err: err}
+	}()
+	waitWatching(t, fc, 2*len(arg.ServiceIds))
+
+	return ret
+}
+
+func waitWatch(t *testing.T, ret <-chan watchResult) watchResult {
+	t.Helper()
+
+	select {
+	case res := <-ret:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not returned")
+	}
+
+	return watchResult{}
+}
+
+func TestDoWatch(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	c := context.Background()
+	arg := testArgRegister("host0")
+	if err := r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+
+	//revision 0 get the current ones at once, the services not registered left out.
+	wi, err := r.DoWatch(c, &ArgDoWatch{ServiceIds: []string{"test.service", "other.service"}, Env: "prod"})
+	if err != nil || wi.Revision == 0 || len(wi.Services) != 1 || len(wi.Services["test.service"].Instances["sh1"]) != 1 {
+		t.Fatalf("watch revision 0 got %v %v", wi, err)
+	}
+
+	//block until registered.
+	ret := goWatch(t, r, fc, c, &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod", Revision: wi.Revision})
+	select {
+	case res := <-ret:
+		t.Fatalf("watch returned before anything changed %v %v", res.wi, res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	arg = testArgRegister("host1")
+	if err = r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	res := waitWatch(t, ret)
+	if res.err != nil || res.wi.Revision <= wi.Revision || len(res.wi.Services["test.service"].Instances["sh1"]) != 2 {
+		t.Fatalf("watch after register got %v %v", res.wi, res.err)
+	}
+	wi = res.wi
+
+	//the lease expired, the instance deleted without the service touched.
+	kv, _ := fc.GetRaw(instanceKey("prod", "test.service", "host1"))
+	ret = goWatch(t, r, fc, c, &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod", Revision: wi.Revision})
+	fc.expire(clientv3.LeaseID(kv.Lease))
+	res = waitWatch(t, ret)
+	if res.err != nil || len(res.wi.Services["test.service"].Instances["sh1"]) != 1 {
+		t.Fatalf("watch after expired got %v %v", res.wi, res.err)
+	}
+
+	//the watches closed along with the long poll.
+	waitWatching(t, fc, 0)
+}
+
+func TestDoWatchChangedBefore(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	c := context.Background()
+	arg := testArgRegister("host0")
+	if err := r.Register(nil, arg, NewInstance(arg)); err != nil {
+		t.Fatalf("register failed %v", err)
+	}
+	wi, err := r.DoWatch(c, &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod"})
+	if err != nil {
+		t.Fatalf("watch failed %v", err)
+	}
+
+	//changed between the two polls, return at once.
+	if err = r.LogOff(nil, testArgLogOff("host0")); err != nil {
+		t.Fatalf("logoff failed %v", err)
+	}
+	wi2, err := r.DoWatch(c, &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod", Revision: wi.Revision})
+	if err != nil || len(wi2.Services["test.service"].Instances) != 0 {
+		t.Fatalf("watch changed before got %v %v", wi2, err)
+	}
+}
+
+func TestDoWatchCanceled(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+	ctx, cancel := context.WithCancel(context.Background())
+	c := ctx
+
+	ret := goWatch(t, r, fc, c, &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod", Revision: 1})
+	cancel()
+	res := waitWatch(t, ret)
+	if !ecode.EqualError(ecode.Deadline, res.err) {
+		t.Fatalf("watch canceled got %v, want deadline", res.err)
+	}
+}
+
+func TestDoWatchClosed(t *testing.T) {
+	fc := newFakeCluster()
+	r := newTestRegistry(t, fc)
+
+	ret := goWatch(t, r, fc, context.Background(), &ArgDoWatch{ServiceIds: []string{"test.service"}, Env: "prod", Revision: 1})
+	r.CloseWatches()
+	res := waitWatch(t, ret)
+	if !ecode.EqualError(ecode.ServiceUnavailable, res.err) {
+		t.Fatalf("watch closed got %v, want service unavailable", res.err)
+	}
+
+	//the registry still serves the requests in flight until closed.
+	registerHosts(t, r, 1)
+	r.RunEvict()
+	if !r.evicting {
+		t.Fatal("evict round not run before the registry closed")
+	}
+}
+
+func TestDoWatchNoServices(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+
+	for _, rev := range []int64{0, 1} {
+		if _, err := r.DoWatch(context.Background(), &ArgDoWatch{Env: "prod", Revision: rev}); !ecode.EqualError(ecode.RequestErr, err) {
+			t.Fatalf("watch no services from revision %d got %v, want request error", rev, err)
+		}
+	}
+}
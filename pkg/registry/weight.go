@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"context"
+	"nmid-registry/pkg/loger"
+)
+
+//SetWeight override the weight of the instance, shifting the traffic without the instance registering again,
+//reset to restore the weight registered.
+func (r *Registry) SetWeight(ctx context.Context, arg *ArgSetWeight) error {
+	unlock, err := r.lockService(arg.Env, arg.ServiceId)
+	if nil != err {
+		return err
+	}
+	defer unlock()
+
+	err = r.overrideInstance(arg.Env, arg.ServiceId, arg.Hostname, func(ins *Instance, o *override) {
+		if arg.Reset {
+			if o.Weight != nil {
+				ins.Weight = o.RegWeight
+			}
+			o.Weight = nil
+			o.RegWeight = 0
+			return
+		}
+
+		if o.Weight == nil {
+			o.RegWeight = ins.Weight
+		}
+		weight := arg.Weight
+		o.Weight = &weight
+		ins.Weight = weight
+	})
+	if nil != err {
+		return err
+	}
+	loger.Loger.Infof("set weight service(%s) env(%s) hostname(%s) weight(%d) reset(%v)", arg.ServiceId, arg.Env, arg.Hostname, arg.Weight, arg.Reset)
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"github.com/go-kratos/kratos/pkg/ecode"
+	"testing"
+)
+
+func testArgSetWeight(hostname string, weight int64) *ArgSetWeight {
+	return &ArgSetWeight{
+		ServiceId: "test.service",
+		Env:       "prod",
+		Hostname:  hostname,
+		Weight:    weight,
+	}
+}
+
+func TestRegisterWeight(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+	if ins := storedInstance(t, r, "host-0"); ins.Weight != DefaultWeight {
+		t.Fatalf("registered with weight %d, want default", ins.Weight)
+	}
+
+	arg := testArgRegister("host-1")
+	arg.Weight = 20
+	if err := r.Register(nil, arg, NewInstance(arg)); nil != err {
+		t.Fatalf("register: %v", err)
+	}
+	if ins := storedInstance(t, r, "host-1"); ins.Weight != 20 {
+		t.Fatalf("registered with weight %d, want 20", ins.Weight)
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+	fetch := &ArgFetchAll{ServiceId: "test.service", Env: "prod"}
+	before, err := r.FetchAll(nil, fetch)
+	if nil != err {
+		t.Fatalf("fetch all: %v", err)
+	}
+
+	if err = r.SetWeight(nil, testArgSetWeight("host-0", 1)); nil != err {
+		t.Fatalf("set weight: %v", err)
+	}
+	info, err := r.FetchAll(nil, fetch)
+	if nil != err || info.Instances["sh1"][0].Weight != 1 {
+		t.Fatalf("fetch all got %+v %v, want weight 1", info, err)
+	}
+	if info.LatestTimestamp <= before.LatestTimestamp {
+		t.Fatal("service not touched by the weight set")
+	}
+
+	//survive the renewals and the registering again with another weight.
+	ins, err := r.Renew(nil, testArgRenew("host-0"))
+	if nil != err || ins.Weight != 1 {
+		t.Fatalf("renew got %v %v, want the weight overridden", ins, err)
+	}
+	arg := testArgRegister("host-0")
+	arg.Weight = 20
+	if err = r.Register(nil, arg, NewInstance(arg)); nil != err {
+		t.Fatalf("register again: %v", err)
+	}
+	if ins = storedInstance(t, r, "host-0"); ins.Weight != 1 {
+		t.Fatalf("registered again with weight %d, want the weight overridden", ins.Weight)
+	}
+
+	//the weight 0 is an override too.
+	if err = r.SetWeight(nil, testArgSetWeight("host-0", 0)); nil != err {
+		t.Fatalf("set weight: %v", err)
+	}
+	if ins = storedInstance(t, r, "host-0"); ins.Weight != 0 {
+		t.Fatalf("weight %d, want 0", ins.Weight)
+	}
+
+	//reset to the one registered latest.
+	reset := testArgSetWeight("host-0", 0)
+	reset.Reset = true
+	if err = r.SetWeight(nil, reset); nil != err {
+		t.Fatalf("reset weight: %v", err)
+	}
+	if ins = storedInstance(t, r, "host-0"); ins.Weight != 20 {
+		t.Fatalf("reset to weight %d, want the registered 20", ins.Weight)
+	}
+	if o := overrideOf(t, r, "host-0"); !o.empty() {
+		t.Fatalf("override %+v left after reset", o)
+	}
+
+	if err = r.SetWeight(nil, testArgSetWeight("host-1", 5)); !ecode.EqualError(ecode.NothingFound, err) {
+		t.Fatalf("set weight of the one not registered got %v, want nothing found", err)
+	}
+}
+
+func TestSetWeightWithStatus(t *testing.T) {
+	r := newTestRegistry(t, newFakeCluster())
+	registerHosts(t, r, 1)
+
+	if err := r.SetStatus(nil, testArgSetStatus("host-0", InstanceDraining)); nil != err {
+		t.Fatalf("set status: %v", err)
+	}
+	if err := r.SetWeight(nil, testArgSetWeight("host-0", 5)); nil != err {
+		t.Fatalf("set weight: %v", err)
+	}
+	if o := overrideOf(t, r, "host-0"); o.Status == nil || *o.Status != InstanceDraining || o.Weight == nil || *o.Weight != 5 {
+		t.Fatalf("override %+v, want both the status and the weight", o)
+	}
+
+	//reset the weight only.
+	reset := testArgSetWeight("host-0", 0)
+	reset.Reset = true
+	if err := r.SetWeight(nil, reset); nil != err {
+		t.Fatalf("reset weight: %v", err)
+	}
+	ins := storedInstance(t, r, "host-0")
+	if ins.Status != InstanceDraining || ins.Weight != DefaultWeight {
+		t.Fatalf("instance status %d weight %d, want draining with the weight registered", ins.Status, ins.Weight)
+	}
+}
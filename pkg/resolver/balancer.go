@@ -0,0 +1,243 @@
+package resolver
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/connectivity"
+	gresolver "google.golang.org/grpc/resolver"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+//ZoneBalancerName split the traffic to the zones by the weights of the scheduler if the service scheduled,
+//otherwise prefer the instances in the same zone as the caller, or all the instances when none of the same zone is ready.
+//the instances in the zone picked are weighted by their weights, round robin if all the same.
+const ZoneBalancerName = "nmid_zone"
+
+func init() {
+	balancer.Register(&zoneBalancerBuilder{})
+}
+
+//zoneBalancerBuilder build the base balancer with the zone picker. the base balancer keys the subconns
+//by the addresses without the balancer attributes, and only rebuilds the picker when the ready ones changed,
+//so the weights changed of the instances connected would never take effect without the zone balancer.
+type zoneBalancerBuilder struct{}
+
+func (*zoneBalancerBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	zb := &zoneBalancer{
+		cc:    cc,
+		addrs: make(map[string]gresolver.Address),
+		state: connectivity.Idle,
+	}
+	bb := base.NewBalancerBuilder(ZoneBalancerName, &zonePickerBuilder{zb: zb}, base.Config{HealthCheck: true})
+	zb.Balancer = bb.Build(&zoneClientConn{ClientConn: cc, zb: zb}, opt)
+
+	return zb
+}
+
+func (*zoneBalancerBuilder) Name() string {
+	return ZoneBalancerName
+}
+
+//zoneBalancer the base balancer building the pickers by the latest attributes of the addresses.
+//the methods of the balancer are called one by one by grpc, no lock needed.
+type zoneBalancer struct {
+	balancer.Balancer
+
+	cc    balancer.ClientConn
+	addrs map[string]gresolver.Address //the latest resolved by the addr
+	ready map[balancer.SubConn]base.SubConnInfo
+	state connectivity.State
+
+	built  balancer.Picker //the last built for the base balancer
+	picker balancer.Picker //the last rebuilt, replaces the built one the base balancer keeps updating with
+}
+
+func (zb *zoneBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := make(map[string]gresolver.Address, len(s.ResolverState.Addresses))
+	for _, addr := range s.ResolverState.Addresses {
+		addrs[addr.Addr] = addr
+	}
+	zb.addrs = addrs
+
+	err := zb.Balancer.UpdateClientConnState(s)
+
+	//the base balancer builds the picker only when the ready ones changed, rebuild it for the attributes changed.
+	if zb.ready != nil && zb.state != connectivity.TransientFailure {
+		zb.picker = zb.build(zb.ready)
+		zb.cc.UpdateState(balancer.State{ConnectivityState: zb.state, Picker: zb.picker})
+	}
+
+	return err
+}
+
+//build the zone picker of the ready subconns by the latest attributes.
+func (zb *zoneBalancer) build(ready map[balancer.SubConn]base.SubConnInfo) balancer.Picker {
+	latest := make(map[balancer.SubConn]base.SubConnInfo, len(ready))
+	for sc, sci := range ready {
+		if addr, ok := zb.addrs[sci.Address.Addr]; ok {
+			sci.Address = addr
+		}
+		latest[sc] = sci
+	}
+
+	return newZonePicker(latest)
+}
+
+//zoneClientConn record the state the base balancer reported, to update the picker rebuilt with it.
+type zoneClientConn struct {
+	balancer.ClientConn
+
+	zb *zoneBalancer
+}
+
+func (zcc *zoneClientConn) UpdateState(s balancer.State) {
+	zcc.zb.state = s.ConnectivityState
+	if s.Picker == zcc.zb.built {
+		s.Picker = zcc.zb.picker
+	}
+	zcc.ClientConn.UpdateState(s)
+}
+
+type zonePickerBuilder struct {
+	zb *zoneBalancer
+}
+
+func (pb *zonePickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	pb.zb.ready = info.ReadySCs
+	pb.zb.built = pb.zb.build(info.ReadySCs)
+	pb.zb.picker = pb.zb.built
+
+	return pb.zb.built
+}
+
+//newZonePicker pick by the zone scheduler or the zone preference, the weights of the instances in the zone picked.
+func newZonePicker(ready map[balancer.SubConn]base.SubConnInfo) balancer.Picker {
+	if len(ready) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	var (
+		local, all = &zoneConns{}, &zoneConns{}
+		scheduled  bool
+		zones      = make(map[string]*zoneConns)
+	)
+	for sc, sci := range ready {
+		weight := instanceWeight(sci.Address)
+		all.add(sc, weight)
+		if isLocal(sci.Address) {
+			local.add(sc, weight)
+		}
+
+		zw, ok := zoneWeight(sci.Address)
+		if !ok {
+			continue
+		}
+		scheduled = true
+		if zw <= 0 {
+			continue
+		}
+		zone := Zone(sci.Address)
+		zc, ok := zones[zone]
+		if !ok {
+			zc = &zoneConns{zone: zone, weight: zw}
+			zones[zone] = zc
+		}
+		zc.add(sc, weight)
+	}
+	for zone, zc := range zones {
+		if len(zc.subConns) == 0 {
+			delete(zones, zone)
+		}
+	}
+
+	//weighted over the zones ready, fall back to the zone preference if none of them ready.
+	if scheduled && len(zones) > 0 {
+		p := &weightedPicker{}
+		for _, zc := range zones {
+			p.zones = append(p.zones, zc)
+			p.total += zc.weight
+		}
+		sort.Slice(p.zones, func(i, j int) bool {
+			return p.zones[i].zone < p.zones[j].zone
+		})
+		return p
+	}
+
+	if len(local.subConns) == 0 {
+		local = all
+	}
+	//all weight 0, taken out of the traffic.
+	if len(local.subConns) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	return &zonePicker{zoneConns: local}
+}
+
+//zoneConns the ready instances of the zone.
+type zoneConns struct {
+	zone   string
+	weight int64
+
+	subConns []balancer.SubConn
+	weights  []int64
+	total    int64
+	weighted bool //not all the same weight
+	next     uint32
+}
+
+//add the instance ready, the one of weight 0 is left out of the picks.
+func (zc *zoneConns) add(sc balancer.SubConn, weight int64) {
+	if weight <= 0 {
+		return
+	}
+	if len(zc.weights) > 0 && zc.weights[0] != weight {
+		zc.weighted = true
+	}
+	zc.subConns = append(zc.subConns, sc)
+	zc.weights = append(zc.weights, weight)
+	zc.total += weight
+}
+
+func (zc *zoneConns) pick() balancer.SubConn {
+	if zc.weighted && zc.total > 0 {
+		n := rand.Int63n(zc.total)
+		for i, weight := range zc.weights {
+			if n < weight {
+				return zc.subConns[i]
+			}
+			n -= weight
+		}
+	}
+
+	n := atomic.AddUint32(&zc.next, 1)
+
+	return zc.subConns[int(n)%len(zc.subConns)]
+}
+
+type zonePicker struct {
+	*zoneConns
+}
+
+func (p *zonePicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: p.pick()}, nil
+}
+
+type weightedPicker struct {
+	zones []*zoneConns
+	total int64
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := rand.Int63n(p.total)
+	for _, zc := range p.zones {
+		if n < zc.weight {
+			return balancer.PickResult{SubConn: zc.pick()}, nil
+		}
+		n -= zc.weight
+	}
+
+	return balancer.PickResult{SubConn: p.zones[len(p.zones)-1].pick()}, nil
+}
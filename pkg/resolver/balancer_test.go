@@ -0,0 +1,297 @@
+package resolver
+
+import (
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/connectivity"
+	gresolver "google.golang.org/grpc/resolver"
+	"testing"
+)
+
+type fakeSubConn struct {
+	addr string
+}
+
+func (sc *fakeSubConn) UpdateAddresses([]gresolver.Address) {}
+
+func (sc *fakeSubConn) Connect() {}
+
+//fakeClientConn record the subconns created and the last state of the balancer.
+type fakeClientConn struct {
+	balancer.ClientConn
+
+	subConns map[string]*fakeSubConn
+	state    balancer.State
+}
+
+func newFakeClientConn() *fakeClientConn {
+	return &fakeClientConn{subConns: make(map[string]*fakeSubConn)}
+}
+
+func (cc *fakeClientConn) NewSubConn(addrs []gresolver.Address, _ balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc := &fakeSubConn{addr: addrs[0].Addr}
+	cc.subConns[sc.addr] = sc
+	return sc, nil
+}
+
+func (cc *fakeClientConn) RemoveSubConn(sc balancer.SubConn) {
+	delete(cc.subConns, sc.(*fakeSubConn).addr)
+}
+
+func (cc *fakeClientConn) UpdateState(s balancer.State) {
+	cc.state = s
+}
+
+type testAddr struct {
+	addr       string
+	zone       string
+	local      bool
+	weight     int64 //not resolved with the weight if 0
+	drained    bool  //resolved with weight 0
+	zoneWeight int64
+	scheduled  bool
+}
+
+func (ta testAddr) address() gresolver.Address {
+	attrs := attributes.New(zoneKey{}, ta.zone).
+		WithValue(localKey{}, ta.local)
+	if ta.weight != 0 || ta.drained {
+		attrs = attrs.WithValue(weightKey{}, ta.weight)
+	}
+	if ta.scheduled {
+		attrs = attrs.WithValue(zoneWeightKey{}, ta.zoneWeight)
+	}
+
+	return gresolver.Address{Addr: ta.addr, BalancerAttributes: attrs}
+}
+
+func readySubConns(tas ...testAddr) map[balancer.SubConn]base.SubConnInfo {
+	ready := make(map[balancer.SubConn]base.SubConnInfo, len(tas))
+	for _, ta := range tas {
+		ready[&fakeSubConn{addr: ta.addr}] = base.SubConnInfo{Address: ta.address()}
+	}
+
+	return ready
+}
+
+//pickTimes pick n times, the times of each addr picked.
+func pickTimes(t *testing.T, p balancer.Picker, n int) map[string]int {
+	t.Helper()
+
+	times := make(map[string]int)
+	for i := 0; i < n; i++ {
+		res, err := p.Pick(balancer.PickInfo{})
+		if err != nil {
+			t.Fatalf("pick failed %v", err)
+		}
+		times[res.SubConn.(*fakeSubConn).addr]++
+	}
+
+	return times
+}
+
+func TestZonePicker(t *testing.T) {
+	cases := []struct {
+		name  string
+		ready []testAddr
+		want  map[string]bool
+	}{
+		{
+			name: "prefer the same zone",
+			ready: []testAddr{
+				{addr: "a", zone: "sh1", local: true},
+				{addr: "b", zone: "sh1", local: true},
+				{addr: "c", zone: "sh2"},
+			},
+			want: map[string]bool{"a": true, "b": true},
+		},
+		{
+			name: "all if none of the same zone",
+			ready: []testAddr{
+				{addr: "b", zone: "sh2"},
+				{addr: "c", zone: "sh3"},
+			},
+			want: map[string]bool{"b": true, "c": true},
+		},
+		{
+			name: "weighted in the zone",
+			ready: []testAddr{
+				{addr: "a", zone: "sh1", local: true, weight: 10},
+				{addr: "b", zone: "sh1", local: true, drained: true},
+				{addr: "c", zone: "sh2", weight: 10},
+			},
+			want: map[string]bool{"a": true},
+		},
+		{
+			name: "scheduled by the zone weights",
+			ready: []testAddr{
+				{addr: "a", zone: "sh1", local: true, scheduled: true, zoneWeight: 0},
+				{addr: "b", zone: "sh2", scheduled: true, zoneWeight: 100},
+				{addr: "c", zone: "sh2", scheduled: true, zoneWeight: 100},
+			},
+			want: map[string]bool{"b": true, "c": true},
+		},
+		{
+			name: "zone preference if none of the zones scheduled ready",
+			ready: []testAddr{
+				{addr: "a", zone: "sh1", local: true, scheduled: true, zoneWeight: 0},
+				{addr: "b", zone: "sh2", scheduled: true, zoneWeight: 0},
+			},
+			want: map[string]bool{"a": true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			times := pickTimes(t, newZonePicker(readySubConns(c.ready...)), 200)
+			for addr := range times {
+				if !c.want[addr] {
+					t.Fatalf("picked %s, want %v", addr, c.want)
+				}
+			}
+			for addr := range c.want {
+				if times[addr] == 0 {
+					t.Fatalf("%s never picked, got %v", addr, times)
+				}
+			}
+		})
+	}
+
+	if _, err := newZonePicker(nil).Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("pick none ready got %v", err)
+	}
+	if balancer.Get(ZoneBalancerName) == nil {
+		t.Fatal("zone balancer not registered")
+	}
+}
+
+func TestWeightedPicker(t *testing.T) {
+	ready := readySubConns(
+		testAddr{addr: "a", zone: "sh1", local: true, scheduled: true, zoneWeight: 80},
+		testAddr{addr: "b", zone: "sh2", scheduled: true, zoneWeight: 20},
+	)
+	times := pickTimes(t, newZonePicker(ready), 10000)
+	//80% expected, far enough from the random noise.
+	if times["a"] < 7500 || times["a"] > 8500 {
+		t.Fatalf("picked %v, want split 80 to 20", times)
+	}
+}
+
+func TestInstanceWeightedPicker(t *testing.T) {
+	ready := readySubConns(
+		testAddr{addr: "a", zone: "sh1", local: true, weight: 30},
+		testAddr{addr: "b", zone: "sh1", local: true, weight: 10},
+	)
+	times := pickTimes(t, newZonePicker(ready), 10000)
+	//75% expected, far enough from the random noise.
+	if times["a"] < 7000 || times["a"] > 8000 {
+		t.Fatalf("picked %v, want split 75 to 25", times)
+	}
+}
+
+func TestZeroWeightPicker(t *testing.T) {
+	ready := readySubConns(
+		testAddr{addr: "a", zone: "sh1", local: true, drained: true},
+		testAddr{addr: "b", zone: "sh1", local: true, weight: 10},
+		testAddr{addr: "c", zone: "sh2", drained: true},
+	)
+	if times := pickTimes(t, newZonePicker(ready), 100); times["b"] != 100 {
+		t.Fatalf("picked %v, want the one not of weight 0 only", times)
+	}
+
+	//the zone scheduled with all of weight 0 is not picked.
+	ready = readySubConns(
+		testAddr{addr: "a", zone: "sh1", local: true, drained: true, scheduled: true, zoneWeight: 80},
+		testAddr{addr: "b", zone: "sh2", weight: 10, scheduled: true, zoneWeight: 20},
+	)
+	if times := pickTimes(t, newZonePicker(ready), 100); times["b"] != 100 {
+		t.Fatalf("picked %v, want the zone not all of weight 0 only", times)
+	}
+
+	ready = readySubConns(
+		testAddr{addr: "a", zone: "sh1", local: true, drained: true},
+		testAddr{addr: "b", zone: "sh2", drained: true},
+	)
+	if _, err := newZonePicker(ready).Pick(balancer.PickInfo{}); err != balancer.ErrNoSubConnAvailable {
+		t.Fatalf("pick all of weight 0 got %v, want no subconn available", err)
+	}
+}
+
+//updateAddrs resolve the addrs to the balancer, and make the subconns new ready.
+func updateAddrs(t *testing.T, b balancer.Balancer, cc *fakeClientConn, tas ...testAddr) {
+	t.Helper()
+
+	connected := make(map[string]bool, len(cc.subConns))
+	for addr := range cc.subConns {
+		connected[addr] = true
+	}
+
+	addrs := make([]gresolver.Address, 0, len(tas))
+	for _, ta := range tas {
+		addrs = append(addrs, ta.address())
+	}
+	if err := b.UpdateClientConnState(balancer.ClientConnState{ResolverState: gresolver.State{Addresses: addrs}}); err != nil {
+		t.Fatalf("update client conn state failed %v", err)
+	}
+
+	for addr, sc := range cc.subConns {
+		if connected[addr] {
+			continue
+		}
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+}
+
+func TestZoneBalancerAttributesChanged(t *testing.T) {
+	cc := newFakeClientConn()
+	b := balancer.Get(ZoneBalancerName).Build(cc, balancer.BuildOptions{})
+	defer b.Close()
+
+	updateAddrs(t, b, cc,
+		testAddr{addr: "a", zone: "sh1", local: true, weight: 10},
+		testAddr{addr: "b", zone: "sh1", local: true, weight: 10},
+	)
+	if cc.state.ConnectivityState != connectivity.Ready {
+		t.Fatalf("balancer state %s, want ready", cc.state.ConnectivityState)
+	}
+	times := pickTimes(t, cc.state.Picker, 200)
+	if times["a"] == 0 || times["b"] == 0 {
+		t.Fatalf("not picked both, got %v", times)
+	}
+
+	//the weight of b changed, the subconns stay the same.
+	updateAddrs(t, b, cc,
+		testAddr{addr: "a", zone: "sh1", local: true, weight: 10},
+		testAddr{addr: "b", zone: "sh1", local: true, drained: true},
+	)
+	if times = pickTimes(t, cc.state.Picker, 200); times["b"] != 0 {
+		t.Fatalf("b picked with weight 0, got %v", times)
+	}
+
+	//a new subconn connecting, the base balancer updates the state with the picker it kept.
+	addrs := []gresolver.Address{
+		testAddr{addr: "a", zone: "sh1", local: true, weight: 10}.address(),
+		testAddr{addr: "b", zone: "sh1", local: true, drained: true}.address(),
+		testAddr{addr: "c", zone: "sh2", weight: 10}.address(),
+	}
+	if err := b.UpdateClientConnState(balancer.ClientConnState{ResolverState: gresolver.State{Addresses: addrs}}); err != nil {
+		t.Fatalf("update client conn state failed %v", err)
+	}
+	b.UpdateSubConnState(cc.subConns["c"], balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+	if times = pickTimes(t, cc.state.Picker, 200); times["b"] != 0 || times["c"] != 0 {
+		t.Fatalf("picked by the attributes outdated, got %v", times)
+	}
+
+	//b moved out of the zone of the caller once ready.
+	b.UpdateSubConnState(cc.subConns["c"], balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	updateAddrs(t, b, cc,
+		testAddr{addr: "a", zone: "sh1", local: true, weight: 10},
+		testAddr{addr: "b", zone: "sh2", weight: 10},
+		testAddr{addr: "c", zone: "sh2", weight: 10},
+	)
+	if times = pickTimes(t, cc.state.Picker, 200); times["a"] != 200 {
+		t.Fatalf("not picked the only one in the same zone, got %v", times)
+	}
+}
@@ -0,0 +1,253 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/attributes"
+	gresolver "google.golang.org/grpc/resolver"
+	"net/url"
+	"nmid-registry/pkg/client"
+	"nmid-registry/pkg/loger"
+	"strings"
+	"sync"
+)
+
+//Scheme dial the service like nmid-registry:///service-id?env=prod&zone=sh1
+const Scheme = "nmid-registry"
+
+type (
+	zoneKey       struct{}
+	localKey      struct{}
+	zoneWeightKey struct{}
+	weightKey     struct{}
+	metadataKey   struct{}
+)
+
+//Metadata the metadata of the instance, comparable for the attributes.
+type Metadata map[string]string
+
+func (m Metadata) Equal(o interface{}) bool {
+	om, ok := o.(Metadata)
+	if !ok || len(m) != len(om) {
+		return false
+	}
+	for k, v := range m {
+		if ov, ok := om[k]; !ok || ov != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Zone get the zone of the instance the address belongs to.
+func Zone(addr gresolver.Address) string {
+	zone, _ := addr.BalancerAttributes.Value(zoneKey{}).(string)
+	return zone
+}
+
+//GetMetadata get the metadata of the instance the address belongs to.
+func GetMetadata(addr gresolver.Address) Metadata {
+	md, _ := addr.BalancerAttributes.Value(metadataKey{}).(Metadata)
+	return md
+}
+
+//isLocal report whether the instance in the same zone as the caller.
+func isLocal(addr gresolver.Address) bool {
+	local, _ := addr.BalancerAttributes.Value(localKey{}).(bool)
+	return local
+}
+
+//zoneWeight the weight of the zone the address in for the caller, false if not scheduled.
+func zoneWeight(addr gresolver.Address) (int64, bool) {
+	weight, ok := addr.BalancerAttributes.Value(zoneWeightKey{}).(int64)
+	return weight, ok
+}
+
+//instanceWeight the weight of the instance the address belongs to.
+func instanceWeight(addr gresolver.Address) int64 {
+	weight, ok := addr.BalancerAttributes.Value(weightKey{}).(int64)
+	if !ok {
+		//resolved without the weight, all the same.
+		return 1
+	}
+	return weight
+}
+
+//Builder resolve the services by watching them from the registry, one client each env.
+type Builder struct {
+	conf client.Config
+
+	mutex   sync.Mutex
+	clients map[string]*client.Client
+}
+
+//Register the builder of the registry to grpc, the env of the config is the default env of the targets.
+func Register(conf *client.Config) *Builder {
+	b := NewBuilder(conf)
+	gresolver.Register(b)
+
+	return b
+}
+
+func NewBuilder(conf *client.Config) *Builder {
+	return &Builder{
+		conf:    *conf,
+		clients: make(map[string]*client.Client),
+	}
+}
+
+func (b *Builder) Scheme() string {
+	return Scheme
+}
+
+func (b *Builder) Build(target gresolver.Target, cc gresolver.ClientConn, opts gresolver.BuildOptions) (gresolver.Resolver, error) {
+	serviceId := strings.TrimPrefix(target.URL.Path, "/")
+	if serviceId == "" {
+		return nil, fmt.Errorf("invalid target %s, service id required", target.URL.String())
+	}
+	query := target.URL.Query()
+	env := query.Get("env")
+	if env == "" {
+		env = b.conf.Env
+	}
+
+	cli, err := b.client(env)
+	if nil != err {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &registryResolver{
+		serviceId: serviceId,
+		zone:      query.Get("zone"),
+		cli:       cli,
+		cc:        cc,
+		cancel:    cancel,
+	}
+	go r.watch(ctx)
+
+	return r, nil
+}
+
+//Close the clients of the builder, the resolvers built stop updating.
+func (b *Builder) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for env, cli := range b.clients {
+		cli.Close()
+		delete(b.clients, env)
+	}
+}
+
+func (b *Builder) client(env string) (*client.Client, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if cli, ok := b.clients[env]; ok {
+		return cli, nil
+	}
+
+	conf := b.conf
+	conf.Env = env
+	cli, err := client.New(&conf)
+	if nil != err {
+		return nil, err
+	}
+	b.clients[env] = cli
+
+	return cli, nil
+}
+
+type registryResolver struct {
+	serviceId string
+	zone      string
+
+	cli    *client.Client
+	cc     gresolver.ClientConn
+	cancel context.CancelFunc
+}
+
+func (r *registryResolver) ResolveNow(gresolver.ResolveNowOptions) {}
+
+func (r *registryResolver) Close() {
+	r.cancel()
+}
+
+func (r *registryResolver) watch(ctx context.Context) {
+	ch := r.cli.Watch(ctx, r.serviceId)
+	if _, err := r.cli.Fetch(r.serviceId); err != nil {
+		loger.Loger.Warnf("resolve service(%s) fetch failed %v, waiting for it", r.serviceId, err)
+	}
+
+	for {
+		select {
+		case <-ch:
+			r.update()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *registryResolver) update() {
+	info, err := r.cli.Fetch(r.serviceId)
+	if nil != err {
+		r.cc.ReportError(err)
+		return
+	}
+
+	state := gresolver.State{Addresses: r.addresses(info)}
+	//prefer the same zone only if the zone of caller is known.
+	if r.zone != "" {
+		state.ServiceConfig = r.cc.ParseServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, ZoneBalancerName))
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		loger.Loger.Warnf("resolve service(%s) update state failed %v", r.serviceId, err)
+	}
+}
+
+//addresses the grpc addresses of the instances, the zone, weight and zone weight by the scheduler in the balancer attributes,
+//the zone balancer picks by the latest of them, the instances connected included.
+func (r *registryResolver) addresses(info *client.InstanceInfo) []gresolver.Address {
+	weights := info.ZoneWeights(r.zone)
+	var addrs []gresolver.Address
+	for zone, inss := range info.Instances {
+		for _, ins := range inss {
+			for _, addr := range ins.Addrs {
+				host, ok := grpcAddr(addr)
+				if !ok {
+					continue
+				}
+				attrs := attributes.New(zoneKey{}, zone).
+					WithValue(localKey{}, r.zone != "" && zone == r.zone).
+					WithValue(weightKey{}, ins.Weight).
+					WithValue(metadataKey{}, Metadata(ins.Metadata))
+				if weights != nil {
+					attrs = attrs.WithValue(zoneWeightKey{}, weights[zone])
+				}
+				addrs = append(addrs, gresolver.Address{
+					Addr:               host,
+					BalancerAttributes: attrs,
+				})
+			}
+		}
+	}
+
+	return addrs
+}
+
+//grpcAddr get the host:port of the grpc addr of the instance, like grpc://127.0.0.1:9000 or 127.0.0.1:9000.
+func grpcAddr(addr string) (string, bool) {
+	if !strings.Contains(addr, "://") {
+		return addr, addr != ""
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "grpc" {
+		return "", false
+	}
+
+	return u.Host, true
+}
@@ -0,0 +1,203 @@
+package resolver
+
+import (
+	"fmt"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	gresolver "google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"nmid-registry/pkg/client"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInstances = `{"sh1":[{"zone":"sh1","hostname":"a","addrs":["grpc://a:9000","http://a:8000"],"metadata":{"weight":"10"},"weight":30}],` +
+	`"sh2":[{"zone":"sh2","hostname":"b","addrs":["b:9000"]}]}`
+
+const testScheduler = `{"zones":[{"src":"sh1","dst":{"sh1":80,"sh2":20}}]}`
+
+//newTestRegistry serve the instances of test.service, the watches not modified after the first one.
+func newTestRegistry(t *testing.T) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/registry/fetch/all", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"code":0,"data":{"instances":%s,"scheduler":%s,"latest_timestamp":1}}`, testInstances, testScheduler)
+	})
+	mux.HandleFunc("/registry/watch", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("revision") == "0" {
+			fmt.Fprintf(w, `{"code":0,"data":{"services":{"test.service":{"instances":%s,"scheduler":%s,"latest_timestamp":1}},"revision":1}}`, testInstances, testScheduler)
+			return
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		fmt.Fprint(w, `{"code":-304}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv.URL
+}
+
+//fakeResolverConn the states updated by the resolver.
+type fakeResolverConn struct {
+	gresolver.ClientConn
+
+	states  chan gresolver.State
+	configs []string
+}
+
+func (cc *fakeResolverConn) UpdateState(s gresolver.State) error {
+	cc.states <- s
+	return nil
+}
+
+func (cc *fakeResolverConn) ReportError(error) {}
+
+func (cc *fakeResolverConn) ParseServiceConfig(js string) *serviceconfig.ParseResult {
+	cc.configs = append(cc.configs, js)
+	return &serviceconfig.ParseResult{}
+}
+
+func buildTarget(t *testing.T, b *Builder, target string) (gresolver.Resolver, *fakeResolverConn, error) {
+	t.Helper()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatalf("parse target %s failed %v", target, err)
+	}
+	cc := &fakeResolverConn{states: make(chan gresolver.State, 16)}
+	r, err := b.Build(gresolver.Target{URL: *u}, cc, gresolver.BuildOptions{})
+
+	return r, cc, err
+}
+
+func TestBuild(t *testing.T) {
+	b := NewBuilder(&client.Config{Nodes: []string{newTestRegistry(t)}, Env: "prod"})
+	defer b.Close()
+
+	if _, _, err := buildTarget(t, b, Scheme+":///?zone=sh1"); err == nil {
+		t.Fatal("built the target without service id")
+	}
+
+	r, cc, err := buildTarget(t, b, Scheme+":///test.service?zone=sh1")
+	if err != nil {
+		t.Fatalf("build failed %v", err)
+	}
+	defer r.Close()
+
+	var state gresolver.State
+	select {
+	case state = <-cc.states:
+	case <-time.After(5 * time.Second):
+		t.Fatal("state not updated")
+	}
+	if len(state.Addresses) != 2 {
+		t.Fatalf("resolved %v, want the grpc addrs only", state.Addresses)
+	}
+	for _, addr := range state.Addresses {
+		switch addr.Addr {
+		case "a:9000":
+			if weight, ok := zoneWeight(addr); Zone(addr) != "sh1" || !isLocal(addr) || GetMetadata(addr)["weight"] != "10" || instanceWeight(addr) != 30 || !ok || weight != 80 {
+				t.Fatalf("attributes of %s wrong", addr.Addr)
+			}
+		case "b:9000":
+			if weight, ok := zoneWeight(addr); Zone(addr) != "sh2" || isLocal(addr) || !ok || weight != 20 {
+				t.Fatalf("attributes of %s wrong", addr.Addr)
+			}
+		default:
+			t.Fatalf("resolved unknown %s", addr.Addr)
+		}
+	}
+	if len(cc.configs) == 0 || !strings.Contains(cc.configs[0], ZoneBalancerName) {
+		t.Fatalf("zone balancer not configured, got %v", cc.configs)
+	}
+	if len(b.clients) != 1 {
+		t.Fatalf("%d clients, want one of the env prod", len(b.clients))
+	}
+}
+
+func TestGrpcAddr(t *testing.T) {
+	cases := []struct {
+		addr string
+		host string
+		ok   bool
+	}{
+		{addr: "grpc://127.0.0.1:9000", host: "127.0.0.1:9000", ok: true},
+		{addr: "127.0.0.1:9000", host: "127.0.0.1:9000", ok: true},
+		{addr: "http://127.0.0.1:8000"},
+		{addr: ""},
+	}
+
+	for _, c := range cases {
+		if host, ok := grpcAddr(c.addr); host != c.host || ok != c.ok {
+			t.Fatalf("grpc addr of %q got %q %v, want %q %v", c.addr, host, ok, c.host, c.ok)
+		}
+	}
+}
+
+func TestMetadataEqual(t *testing.T) {
+	m := Metadata{"weight": "10"}
+	if !m.Equal(Metadata{"weight": "10"}) || m.Equal(Metadata{"weight": "1"}) || m.Equal(Metadata{}) || m.Equal("weight") {
+		t.Fatal("metadata compared wrong")
+	}
+}
+
+func TestSchedulerChangedConnected(t *testing.T) {
+	r := &registryResolver{serviceId: "test.service", zone: "sh1"}
+	info := &client.InstanceInfo{
+		Instances: map[string][]*client.Instance{
+			"sh1": {{Zone: "sh1", HostName: "a", Addrs: []string{"grpc://a:9000"}, Weight: 10}},
+			"sh2": {{Zone: "sh2", HostName: "b", Addrs: []string{"grpc://b:9000", "http://b:8000"}, Weight: 10}},
+		},
+	}
+
+	cc := newFakeClientConn()
+	b := balancer.Get(ZoneBalancerName).Build(cc, balancer.BuildOptions{})
+	defer b.Close()
+	update := func() {
+		t.Helper()
+
+		addrs := r.addresses(info)
+		if len(addrs) != 2 {
+			t.Fatalf("resolved %d addresses, want 2", len(addrs))
+		}
+		if err := b.UpdateClientConnState(balancer.ClientConnState{ResolverState: gresolver.State{Addresses: addrs}}); err != nil {
+			t.Fatalf("update client conn state failed %v", err)
+		}
+	}
+
+	update()
+	for _, sc := range cc.subConns {
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Connecting})
+		b.UpdateSubConnState(sc, balancer.SubConnState{ConnectivityState: connectivity.Ready})
+	}
+	if times := pickTimes(t, cc.state.Picker, 200); times["a:9000"] != 200 {
+		t.Fatalf("not picked the same zone without the scheduler, got %v", times)
+	}
+
+	//all the traffic of sh1 moved to sh2, the connections kept.
+	info.Scheduler = &client.Scheduler{Zones: []*client.ZoneScheduler{{Src: "sh1", Dst: map[string]int64{"sh1": 0, "sh2": 100}}}}
+	update()
+	if times := pickTimes(t, cc.state.Picker, 200); times["b:9000"] != 200 {
+		t.Fatalf("not picked by the scheduler, got %v", times)
+	}
+
+	//half and half.
+	info.Scheduler.Zones[0].Dst = map[string]int64{"sh1": 50, "sh2": 50}
+	update()
+	if times := pickTimes(t, cc.state.Picker, 200); times["a:9000"] == 0 || times["b:9000"] == 0 {
+		t.Fatalf("not picked both by the scheduler, got %v", times)
+	}
+
+	//the scheduler removed, back to the zone preference.
+	info.Scheduler = nil
+	update()
+	if times := pickTimes(t, cc.state.Picker, 200); times["a:9000"] != 200 {
+		t.Fatalf("not picked the same zone after the scheduler removed, got %v", times)
+	}
+}